@@ -5,9 +5,13 @@ import (
 	"os"
 
 	"github.com/khofesh/img-upload-view/internal/app/api"
+	"github.com/khofesh/img-upload-view/internal/background"
 	"github.com/khofesh/img-upload-view/internal/config"
 	"github.com/khofesh/img-upload-view/internal/data"
 	"github.com/khofesh/img-upload-view/internal/db"
+	"github.com/khofesh/img-upload-view/internal/events"
+	"github.com/khofesh/img-upload-view/internal/presign"
+	"github.com/khofesh/img-upload-view/internal/storage"
 	"github.com/khofesh/img-upload-view/pkg/errors"
 	readconfig "github.com/khofesh/img-upload-view/pkg/read-config"
 	"github.com/rs/zerolog"
@@ -37,11 +41,38 @@ func main() {
 	defer db.Close()
 	log.Info().Msg("database connection pool established.")
 
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = storage.DefaultUploadDir
+	}
+
+	store, err := storage.NewLocalStorage(uploadDir)
+	if err != nil {
+		panic(err)
+	}
+
+	var signer *presign.Signer
+	if len(cfg.Signing.Keys) > 0 {
+		// POST /presign needs a key to hand signed URLs to
+		if len(cfg.Auth.APIKeys) == 0 && len(cfg.Auth.AdminAPIKeys) == 0 {
+			panic("signing keys are configured but no API or admin keys: nobody could request a presigned URL")
+		}
+
+		signer, err = presign.NewSigner(cfg.Signing.ActiveKeyID, cfg.Signing.Keys)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	app := &config.Application{
 		Logger:        &log.Logger,
 		Config:        &cfg,
 		Models:        data.NewModels(db, &log.Logger),
 		ErrorResponse: errors.NewErrorResponse(&log.Logger),
+		Storage:       store,
+		Signer:        signer,
+		Background:    background.NewManager(),
+		Events:        events.NewHub(),
 	}
 
 	err = api.Serve(app)
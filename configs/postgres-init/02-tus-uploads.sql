@@ -0,0 +1,10 @@
+-- resumable uploads (tus protocol), chunks live in the storage backend
+CREATE TABLE IF NOT EXISTS tus_uploads (
+    id VARCHAR(64) PRIMARY KEY,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    image_id INTEGER REFERENCES images(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
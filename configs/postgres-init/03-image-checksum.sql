@@ -0,0 +1,3 @@
+-- sha256 of the stored bytes, used as strong ETag when serving image files.
+-- rows uploaded before this column existed get it filled in on first download
+ALTER TABLE images ADD COLUMN IF NOT EXISTS checksum VARCHAR(64);
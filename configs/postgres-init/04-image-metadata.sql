@@ -0,0 +1,5 @@
+-- user editable metadata, version is bumped on every update for optimistic locking
+ALTER TABLE images ADD COLUMN IF NOT EXISTS title VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS alt_text VARCHAR(500) NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,47 @@
+-- full-text search document of an image. The 'simple' configuration keeps
+-- words as they are, so prefix queries match what the user typed
+CREATE OR REPLACE FUNCTION image_search_document(img images) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('simple', img.title), 'A') ||
+        setweight(to_tsvector('simple', regexp_replace(img.original_filename, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE((
+            SELECT string_agg(t.name, ' ')
+            FROM image_tags it
+            JOIN tags t ON t.id = it.tag_id
+            WHERE it.image_id = img.id
+        ), '')), 'B') ||
+        setweight(to_tsvector('simple', img.description), 'C')
+$$ LANGUAGE sql STABLE;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+UPDATE images SET search_vector = image_search_document(images) WHERE search_vector IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_images_search_vector ON images USING GIN (search_vector);
+
+-- keep search_vector up to date when an image is edited
+CREATE OR REPLACE FUNCTION images_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := image_search_document(NEW);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS images_search_vector ON images;
+CREATE TRIGGER images_search_vector
+    BEFORE INSERT OR UPDATE OF original_filename, title, description ON images
+    FOR EACH ROW EXECUTE FUNCTION images_search_vector_trigger();
+
+-- and when it is tagged or untagged
+CREATE OR REPLACE FUNCTION image_tags_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    UPDATE images SET search_vector = image_search_document(images)
+    WHERE id = COALESCE(NEW.image_id, OLD.image_id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS image_tags_search_vector ON image_tags;
+CREATE TRIGGER image_tags_search_vector
+    AFTER INSERT OR DELETE ON image_tags
+    FOR EACH ROW EXECUTE FUNCTION image_tags_search_vector_trigger();
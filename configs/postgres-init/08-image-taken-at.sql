@@ -0,0 +1,7 @@
+-- capture time read from the EXIF data of the uploaded file, NULL when unknown
+ALTER TABLE images ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP WITH TIME ZONE;
+
+-- indexes for the sort options of GET /images
+CREATE INDEX IF NOT EXISTS idx_images_file_size ON images(file_size, id);
+CREATE INDEX IF NOT EXISTS idx_images_original_filename ON images(original_filename, id);
+CREATE INDEX IF NOT EXISTS idx_images_taken_at ON images(taken_at, id);
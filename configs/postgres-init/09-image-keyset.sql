@@ -0,0 +1,4 @@
+-- keyset pagination of GET /images walks (upload_timestamp, id) in either
+-- direction, replacing the single column index
+CREATE INDEX IF NOT EXISTS idx_images_upload_timestamp_id ON images(upload_timestamp, id);
+DROP INDEX IF EXISTS idx_images_upload_timestamp;
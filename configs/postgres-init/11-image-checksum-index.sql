@@ -0,0 +1,2 @@
+-- duplicate detection during bulk imports looks images up by content hash
+CREATE INDEX IF NOT EXISTS idx_images_checksum ON images(checksum);
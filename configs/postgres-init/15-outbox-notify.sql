@@ -0,0 +1,15 @@
+-- wake the API instances that stream image events (GET /events) when new
+-- events are committed. The notification carries no payload, listeners read
+-- the new rows from the outbox, and Postgres folds the notifications of one
+-- transaction into one.
+CREATE OR REPLACE FUNCTION outbox_notify_trigger() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', '');
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_notify ON outbox;
+CREATE TRIGGER outbox_notify
+    AFTER INSERT ON outbox
+    FOR EACH STATEMENT EXECUTE FUNCTION outbox_notify_trigger();
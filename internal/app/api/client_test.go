@@ -0,0 +1,429 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/background"
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/pkg/client"
+	errres "github.com/khofesh/img-upload-view/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// The tests of pkg/client run against the handler of routes(), with the
+// image model kept in memory. They live here because routes is unexported.
+
+const testAdminKey = "admin-key"
+
+// fakeImages keeps images in memory. Only the methods the client's routes
+// use are implemented, the others panic through the nil embedded interface.
+type fakeImages struct {
+	data.IImageModel
+
+	mu     sync.Mutex
+	images []*data.Image
+}
+
+func (m *fakeImages) Insert(image *data.Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	image.ID = int64(len(m.images) + 1)
+	image.Version = 1
+	image.CreatedAt, image.UpdatedAt = image.UploadTimestamp, image.UploadTimestamp
+	image.Tags = []string{}
+
+	stored := *image
+	m.images = append(m.images, &stored)
+	return nil
+}
+
+func (m *fakeImages) get(id int64, withTrashed bool) (*data.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, image := range m.images {
+		if image.ID == id && (withTrashed || image.DeletedAt == nil) {
+			copied := *image
+			return &copied, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+func (m *fakeImages) GetByID(id int64) (*data.Image, error) {
+	return m.get(id, false)
+}
+
+func (m *fakeImages) GetByIDWithTrashed(id int64) (*data.Image, error) {
+	return m.get(id, true)
+}
+
+func (m *fakeImages) GetAfter(limit int64, cursor *data.ImageCursor, filters data.ImageFilters) ([]*data.Image, *data.ImageCursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	descending := strings.HasPrefix(filters.Sort, "-")
+
+	list := []*data.Image{}
+	for _, image := range m.images {
+		if image.DeletedAt != nil {
+			continue
+		}
+		if cursor != nil && (descending && image.ID >= cursor.ID || !descending && image.ID <= cursor.ID) {
+			continue
+		}
+		list = append(list, image)
+	}
+
+	if descending {
+		slices.Reverse(list)
+	}
+
+	if int64(len(list)) <= limit {
+		return list, nil, nil
+	}
+
+	list = list[:limit]
+	last := list[len(list)-1]
+	return list, &data.ImageCursor{Sort: filters.Sort, UploadTimestamp: last.UploadTimestamp, ID: last.ID}, nil
+}
+
+func (m *fakeImages) Trash(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, image := range m.images {
+		if image.ID == id && image.DeletedAt == nil {
+			now := time.Now()
+			image.DeletedAt = &now
+			return nil
+		}
+	}
+	return data.ErrRecordNotFound
+}
+
+func (m *fakeImages) Delete(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, image := range m.images {
+		if image.ID == id {
+			m.images = slices.Delete(m.images, i, i+1)
+			return nil
+		}
+	}
+	return data.ErrRecordNotFound
+}
+
+type testServer struct {
+	*httptest.Server
+	app *config.Application
+	// requests counts the requests per "METHOD /path"
+	requests sync.Map
+	// unavailable is how many of the next requests get a 503
+	unavailable atomic.Int32
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	st, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zerolog.Nop()
+
+	cfg := &config.Config{}
+	cfg.Auth.AdminAPIKeys = []string{testAdminKey}
+
+	images := &fakeImages{}
+
+	app := &config.Application{
+		Logger:        &logger,
+		Config:        cfg,
+		Models:        data.Models{Image: images, Upload: &fakeUploads{images: images, uploads: map[string]*data.Upload{}}},
+		ErrorResponse: errres.NewErrorResponse(&logger),
+		Storage:       st,
+		Background:    background.NewManager(),
+	}
+
+	handler, _ := routes(app)
+
+	ts := &testServer{app: app}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := ts.requests.LoadOrStore(r.Method+" "+r.URL.Path, new(atomic.Int32))
+		count.(*atomic.Int32).Add(1)
+
+		// like a proxy in front of an API that is restarting
+		if ts.unavailable.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func (ts *testServer) count(route string) int32 {
+	count, ok := ts.requests.Load(route)
+	if !ok {
+		return 0
+	}
+	return count.(*atomic.Int32).Load()
+}
+
+func (ts *testServer) client(t *testing.T, opts ...client.Option) *client.Client {
+	t.Helper()
+
+	opts = append([]client.Option{client.WithRetries(2, time.Millisecond, 10*time.Millisecond)}, opts...)
+
+	c, err := client.New(ts.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 64)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func upload(t *testing.T, c *client.Client, n int) []*client.Image {
+	t.Helper()
+
+	images := []*client.Image{}
+	for range n {
+		image, err := c.Upload(context.Background(), "photo.jpg", bytes.NewReader(testJPEG(t)), nil)
+		if err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+func TestClientUpload(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+	content := testJPEG(t)
+
+	sent := []int64{}
+	image, err := c.Upload(context.Background(), "photo.jpg", bytes.NewReader(content), func(n int64) {
+		sent = append(sent, n)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.ID != 1 || image.OriginalFilename != "photo.jpg" || image.FileSize != int64(len(content)) || image.ContentType != "image/jpeg" {
+		t.Errorf("got image %+v", image)
+	}
+
+	if len(sent) == 0 || sent[len(sent)-1] != int64(len(content)) || !slices.IsSorted(sent) {
+		t.Errorf("got progress %v for %d bytes", sent, len(content))
+	}
+
+	obj, err := ts.app.Storage.Open(context.Background(), image.Filename)
+	if err != nil {
+		t.Fatalf("stored file: %v", err)
+	}
+	obj.Close()
+
+	got, err := c.Get(context.Background(), image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Filename != image.Filename {
+		t.Errorf("got %s, want %s", got.Filename, image.Filename)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+	upload(t, c, 5)
+
+	tests := []struct {
+		opts client.ListOptions
+		want []int64
+	}{
+		{client.ListOptions{PageSize: 2}, []int64{1, 2, 3, 4, 5}},
+		{client.ListOptions{PageSize: 2, Descending: true}, []int64{5, 4, 3, 2, 1}},
+		{client.ListOptions{PageSize: 5}, []int64{1, 2, 3, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		before := ts.count("GET /images")
+
+		ids := []int64{}
+		for image, err := range c.List(context.Background(), tt.opts) {
+			if err != nil {
+				t.Fatalf("%+v: %v", tt.opts, err)
+			}
+			ids = append(ids, image.ID)
+		}
+
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.opts, ids, tt.want)
+		}
+
+		pages := int32((len(tt.want) + tt.opts.PageSize - 1) / tt.opts.PageSize)
+		if got := ts.count("GET /images") - before; got != pages {
+			t.Errorf("%+v: got %d requests, want %d", tt.opts, got, pages)
+		}
+	}
+
+	// stopping early doesn't fetch the next page
+	before := ts.count("GET /images")
+	for range c.List(context.Background(), client.ListOptions{PageSize: 2}) {
+		break
+	}
+	if got := ts.count("GET /images") - before; got != 1 {
+		t.Errorf("got %d requests after break, want 1", got)
+	}
+}
+
+func TestClientDelete(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+	images := upload(t, c, 2)
+
+	err := c.Delete(context.Background(), images[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get(context.Background(), images[0].ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("get after delete: got error %v, want %v", err, client.ErrNotFound)
+	}
+
+	// trashed, the file stays
+	_, err = ts.app.Storage.Stat(context.Background(), images[0].Filename)
+	if err != nil {
+		t.Errorf("file of trashed image: %v", err)
+	}
+
+	err = c.DeletePermanently(context.Background(), images[1].ID)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("permanent delete without admin key: got error %v, want %v", err, client.ErrForbidden)
+	}
+
+	admin := ts.client(t, client.WithAPIKey(testAdminKey))
+	for _, image := range images {
+		err = admin.DeletePermanently(context.Background(), image.ID)
+		if err != nil {
+			t.Fatalf("permanent delete of %d: %v", image.ID, err)
+		}
+
+		_, err = ts.app.Storage.Stat(context.Background(), image.Filename)
+		if !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("file of deleted image %d: got error %v, want %v", image.ID, err, storage.ErrObjectNotFound)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+
+	_, err := c.Get(context.Background(), 42)
+
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v (%T), want *client.Error", err, err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Message == "" || !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("got %+v", apiErr)
+	}
+	if got := ts.count("GET /image/42"); got != 1 {
+		t.Errorf("a 404 was sent %d times", got)
+	}
+
+	_, err = c.ListPage(context.Background(), client.ListOptions{MinSize: 10, MaxSize: 5}, "")
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v (%T), want *client.Error", err, err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Fields["max_size"] == "" || !errors.Is(err, client.ErrValidation) {
+		t.Errorf("got %+v", apiErr)
+	}
+
+	// the iterator hands out the same error
+	for _, err := range c.List(context.Background(), client.ListOptions{MinSize: 10, MaxSize: 5}) {
+		if !errors.Is(err, client.ErrValidation) {
+			t.Errorf("List: got error %v, want %v", err, client.ErrValidation)
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.client(t)
+	images := upload(t, c, 1)
+
+	// retried until the server is back
+	ts.unavailable.Store(2)
+	before := ts.count("GET /image/1")
+	_, err := c.Get(context.Background(), images[0].ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got := ts.count("GET /image/1") - before; got != 3 {
+		t.Errorf("get: got %d attempts, want 3", got)
+	}
+
+	// a seekable upload is sent again from the start
+	ts.unavailable.Store(1)
+	content := testJPEG(t)
+	image, err := c.Upload(context.Background(), "again.jpg", bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if image.FileSize != int64(len(content)) {
+		t.Errorf("upload: stored %d bytes, want %d", image.FileSize, len(content))
+	}
+
+	// the API refuses uploads once it is shutting down, the client gives up
+	// after its retries
+	err = ts.app.Background.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before = ts.count("POST /upload")
+	_, err = c.Upload(context.Background(), "late.jpg", bytes.NewReader(content), nil)
+
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("upload while shutting down: got error %v, want a 503", err)
+	}
+	if got := ts.count("POST /upload") - before; got != 3 {
+		t.Errorf("upload while shutting down: got %d attempts, want 3", got)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/events"
+	"github.com/khofesh/img-upload-view/internal/outbox"
+	"github.com/lib/pq"
+)
+
+const (
+	// channel notified by the outbox_notify trigger
+	eventsChannel = "outbox_events"
+
+	// the outbox is read this often without a notification as well, in
+	// case one was lost with the connection
+	eventsPollInterval = 30 * time.Second
+	// an id below one seen already is waited for this long, the transaction
+	// that took it may still commit
+	eventsGapTimeout = 10 * time.Second
+	// events read per query
+	eventsBatchSize = 100
+
+	eventsMinReconnect = time.Second
+	eventsMaxReconnect = time.Minute
+)
+
+// runEventListener publishes the events committed to the outbox to hub,
+// woken by LISTEN/NOTIFY, until ctx is done. Every instance sees every
+// event, whichever instance wrote it.
+func runEventListener(ctx context.Context, app *config.Application, hub *events.Hub) {
+	defer hub.Close()
+
+	listener := pq.NewListener(app.Config.Db.Dsn, eventsMinReconnect, eventsMaxReconnect,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				app.Logger.Warn().Err(err).Msg("event listener connection problem")
+			}
+		})
+
+	// Listen blocks until the server answered, closing the listener stops it
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer func() {
+		if stop() {
+			listener.Close()
+		}
+	}()
+
+	err := listener.Listen(eventsChannel)
+	if err != nil {
+		if ctx.Err() == nil {
+			app.Logger.Error().Err(err).Msg("failed to listen for image events")
+		}
+		return
+	}
+
+	latest, err := app.Models.Outbox.LatestID()
+	for err != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsMinReconnect):
+		}
+		latest, err = app.Models.Outbox.LatestID()
+	}
+
+	cursor := newEventCursor(latest)
+
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Notify:
+			// nil after a reconnect, events may have been missed meanwhile
+		case <-ticker.C:
+			go listener.Ping()
+		}
+
+		readEvents(app, hub, cursor)
+	}
+}
+
+func readEvents(app *config.Application, hub *events.Hub, cursor *eventCursor) {
+	for {
+		list, err := app.Models.Outbox.ListAfter(cursor.next-1, cursor.seenIDs(), eventsBatchSize)
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to read image events")
+			return
+		}
+
+		for _, event := range list {
+			hub.Publish(outbox.NewMessage(event))
+			cursor.add(event.ID)
+		}
+		cursor.advance(time.Now())
+
+		if len(list) < eventsBatchSize {
+			return
+		}
+	}
+}
+
+// eventCursor follows the outbox in id order. An id is taken when a row is
+// inserted but only shows up once its transaction commits, so a lower id can
+// show up after a higher one. The cursor stays at the lowest id not seen yet
+// for up to eventsGapTimeout, then skips it: the insert may have been rolled
+// back.
+type eventCursor struct {
+	// lowest id not seen yet
+	next int64
+	// ids above next that were seen
+	seen map[int64]bool
+	// when the gap at gapAt was noticed
+	gapSince time.Time
+	gapAt    int64
+}
+
+func newEventCursor(latest int64) *eventCursor {
+	return &eventCursor{next: latest + 1, seen: map[int64]bool{}}
+}
+
+func (c *eventCursor) seenIDs() []int64 {
+	ids := make([]int64, 0, len(c.seen))
+	for id := range c.seen {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (c *eventCursor) add(id int64) {
+	if id >= c.next {
+		c.seen[id] = true
+	}
+}
+
+// advance moves next past the ids seen, and past a gap that is older than
+// eventsGapTimeout
+func (c *eventCursor) advance(now time.Time) {
+	c.compact()
+	if len(c.seen) == 0 {
+		c.gapSince = time.Time{}
+		return
+	}
+
+	if c.gapSince.IsZero() || c.gapAt != c.next {
+		c.gapSince, c.gapAt = now, c.next
+		return
+	}
+
+	if now.Sub(c.gapSince) >= eventsGapTimeout {
+		c.next = slices.Min(c.seenIDs())
+		c.compact()
+
+		c.gapSince, c.gapAt = time.Time{}, 0
+		if len(c.seen) > 0 {
+			c.gapSince, c.gapAt = now, c.next
+		}
+	}
+}
+
+func (c *eventCursor) compact() {
+	for c.seen[c.next] {
+		delete(c.seen, c.next)
+		c.next++
+	}
+}
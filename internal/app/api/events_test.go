@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/events"
+	"github.com/khofesh/img-upload-view/internal/outbox"
+)
+
+// fakeOutbox holds the events with ids 1 to n
+type fakeOutbox struct {
+	data.IOutboxModel
+
+	n int64
+}
+
+func (m *fakeOutbox) ListAfter(after int64, skip []int64, limit int) ([]*data.OutboxEvent, error) {
+	list := []*data.OutboxEvent{}
+	for id := after + 1; id <= m.n && len(list) < limit; id++ {
+		if slices.Contains(skip, id) {
+			continue
+		}
+		list = append(list, &data.OutboxEvent{ID: id, Event: data.EventImageCreated, Payload: json.RawMessage(`{}`)})
+	}
+	return list, nil
+}
+
+// streamEvents opens GET /events and returns the ids of the events it
+// receives, until it has want of them
+func (ts *testServer) streamEvents(t *testing.T, query, lastEventID string, want int, afterReplay func()) []int64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	ids := []int64{}
+	scanner := bufio.NewScanner(res.Body)
+	for len(ids) < want && scanner.Scan() {
+		s, ok := strings.CutPrefix(scanner.Text(), "id: ")
+		if !ok {
+			continue
+		}
+
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid event id %q", s)
+		}
+		ids = append(ids, id)
+
+		if afterReplay != nil && id == ts.app.Models.Outbox.(*fakeOutbox).n {
+			afterReplay()
+		}
+	}
+
+	return ids
+}
+
+func TestStreamEventsReplay(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.Events = events.NewHub()
+	ts.app.Models.Outbox = &fakeOutbox{n: 5}
+
+	// the replayed events, then the live ones without the events replayed
+	// already
+	ids := ts.streamEvents(t, "", "2", 4, func() {
+		ts.app.Events.Publish(outbox.Message{ID: 4, Type: data.EventImageCreated})
+		ts.app.Events.Publish(outbox.Message{ID: 5, Type: data.EventImageCreated})
+		ts.app.Events.Publish(outbox.Message{ID: 6, Type: data.EventImageCreated})
+	})
+	if !slices.Equal(ids, []int64{3, 4, 5, 6}) {
+		t.Errorf("Last-Event-ID 2: got ids %v", ids)
+	}
+
+	// the header wins over the query parameter
+	ids = ts.streamEvents(t, "?last_event_id=1", "4", 1, nil)
+	if !slices.Equal(ids, []int64{5}) {
+		t.Errorf("Last-Event-ID 4 and last_event_id 1: got ids %v", ids)
+	}
+
+	ids = ts.streamEvents(t, "?last_event_id=3", "", 2, nil)
+	if !slices.Equal(ids, []int64{4, 5}) {
+		t.Errorf("last_event_id 3: got ids %v", ids)
+	}
+}
+
+func TestStreamEventsReplaysInBatches(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.Events = events.NewHub()
+	ts.app.Models.Outbox = &fakeOutbox{n: 250}
+
+	ids := ts.streamEvents(t, "", "1", 249, nil)
+	if len(ids) != 249 || ids[0] != 2 || ids[248] != 250 || !slices.IsSorted(ids) {
+		t.Errorf("got %d ids from %v to %v", len(ids), ids[0], ids[len(ids)-1])
+	}
+}
+
+func TestStreamEventsInvalidLastEventID(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.Events = events.NewHub()
+	ts.app.Models.Outbox = &fakeOutbox{}
+
+	for _, id := range []string{"abc", "-1", "1.5"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Last-Event-ID", id)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, want %d", id, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestEventCursor(t *testing.T) {
+	start := time.Now()
+
+	c := newEventCursor(10)
+	if c.next != 11 {
+		t.Fatalf("got next %d, want 11", c.next)
+	}
+
+	// 12 committed before 11, which is waited for
+	c.add(12)
+	c.advance(start)
+	if c.next != 11 {
+		t.Fatalf("gap at 11: got next %d", c.next)
+	}
+
+	c.advance(start.Add(eventsGapTimeout / 2))
+	if c.next != 11 {
+		t.Fatalf("gap at 11 before the timeout: got next %d", c.next)
+	}
+
+	// 11 shows up, the cursor moves past both
+	c.add(11)
+	c.advance(start.Add(eventsGapTimeout / 2))
+	if c.next != 13 || len(c.seen) != 0 {
+		t.Fatalf("gap filled: got next %d, seen %v", c.next, c.seenIDs())
+	}
+
+	// 13 and 14 never show up, the cursor skips them after the timeout
+	c.add(15)
+	c.add(17)
+	c.advance(start)
+	c.advance(start.Add(eventsGapTimeout))
+	if c.next != 16 {
+		t.Fatalf("gap at 13 after the timeout: got next %d", c.next)
+	}
+
+	// the timeout of the next gap starts over
+	c.advance(start.Add(eventsGapTimeout + time.Second))
+	if c.next != 16 {
+		t.Fatalf("gap at 16 right after the previous one: got next %d", c.next)
+	}
+	c.advance(start.Add(2*eventsGapTimeout + time.Second))
+	if c.next != 18 || len(c.seen) != 0 {
+		t.Fatalf("gap at 16 after the timeout: got next %d, seen %v", c.next, c.seenIDs())
+	}
+
+	// ids below next are not kept
+	c.add(3)
+	if len(c.seen) != 0 {
+		t.Fatalf("got seen %v", c.seenIDs())
+	}
+}
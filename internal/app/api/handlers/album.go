@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// GetAlbums - GET /albums
+func GetAlbums(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albums, err := app.Models.Album.GetAll()
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve albums: %v", err))
+			return
+		}
+
+		response := albumsResponse{
+			Albums: albums,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// CreateAlbum - POST /albums {"name": ..., "description": ...}
+func CreateAlbum(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+
+		err := reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		album := &data.Album{
+			Name:        strings.TrimSpace(input.Name),
+			Description: strings.TrimSpace(input.Description),
+		}
+
+		v := validator.New()
+		if data.ValidateAlbum(v, album); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.Models.Album.Insert(album)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to create album: %v", err))
+			return
+		}
+
+		response := albumResponse{
+			Message: "Album created successfully",
+			Album:   album,
+		}
+
+		headers := http.Header{"Location": {fmt.Sprintf("/albums/%d", album.ID)}}
+
+		err = reqres.WriteJSON(w, http.StatusCreated, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// GetAlbum - GET /albums/:id
+func GetAlbum(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		album, ok := readAlbum(app, w, r)
+		if !ok {
+			return
+		}
+
+		response := albumResponse{
+			Album: album,
+		}
+
+		headers := http.Header{"ETag": {reqres.VersionETag(album.Version)}}
+
+		err := reqres.WriteJSON(w, http.StatusOK, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// UpdateAlbum - PATCH /albums/:id renames an album or sets its cover image.
+// "cover_image_id": null removes the cover
+func UpdateAlbum(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expectedVersion, checkVersion, err := reqres.ReadIfMatchVersion(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		album, ok := readAlbum(app, w, r)
+		if !ok {
+			return
+		}
+
+		if checkVersion && album.Version != expectedVersion {
+			app.ErrorResponse.PreconditionFailedResponse(w, r)
+			return
+		}
+
+		var input struct {
+			Name         *string         `json:"name"`
+			Description  *string         `json:"description"`
+			CoverImageID optional[int64] `json:"cover_image_id"`
+		}
+
+		err = reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Name != nil {
+			album.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Description != nil {
+			album.Description = strings.TrimSpace(*input.Description)
+		}
+		if input.CoverImageID.Set {
+			album.CoverImageID = input.CoverImageID.Value
+		}
+
+		v := validator.New()
+		data.ValidateAlbum(v, album)
+
+		if input.CoverImageID.Set && album.CoverImageID != nil {
+			inAlbum, err := app.Models.Album.HasImage(album.ID, *album.CoverImageID)
+			if err != nil {
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to check album image: %v", err))
+				return
+			}
+			v.Check(inAlbum, "cover_image_id", "must be an image in the album")
+		}
+
+		if !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.Models.Album.Update(album)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.ErrorResponse.EditConflictResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to update album: %v", err))
+			return
+		}
+
+		response := albumResponse{
+			Message: "Album updated successfully",
+			Album:   album,
+		}
+
+		headers := http.Header{"ETag": {reqres.VersionETag(album.Version)}}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// DeleteAlbum - DELETE /albums/:id removes the album, the images stay
+func DeleteAlbum(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.Models.Album.Delete(albumId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to delete album: %v", err))
+			return
+		}
+
+		response := messageResponse{
+			Message: "Album deleted successfully",
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// GetAlbumImages - GET /albums/:id/images, paginated like GET /images
+func GetAlbumImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		limit, offset := readPage(r.URL.Query(), v, 20, 20)
+		if !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		album, ok := readAlbum(app, w, r)
+		if !ok {
+			return
+		}
+
+		images, totalCount, err := app.Models.Album.GetImages(album.ID, limit, offset)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve album images: %v", err))
+			return
+		}
+
+		response := albumImagesResponse{
+			Album:    album,
+			Images:   images,
+			Metadata: paginationMetadata(totalCount, limit, offset),
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// AddAlbumImages - POST /albums/:id/images {"image_ids": [...]} appends
+// images to the album
+func AddAlbumImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageIDs, ok := readAlbumImageIDs(app, w, r)
+		if !ok {
+			return
+		}
+
+		album, ok := readAlbum(app, w, r)
+		if !ok {
+			return
+		}
+
+		err := app.Models.Album.AddImages(album.ID, imageIDs)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrImageNotExists):
+				app.ErrorResponse.FailedValidationResponse(w, r, map[string]string{
+					"image_ids": "must only contain ids of existing images that are not in the trash",
+				})
+				return
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to add images to album: %v", err))
+			return
+		}
+
+		writeAlbum(app, w, r, album.ID, "Images added to album successfully")
+	}
+}
+
+// RemoveAlbumImage - DELETE /albums/:id/images/:image_id
+func RemoveAlbumImage(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albumId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		imageId, err := reqres.ReadNamedIDParam(r, "image_id")
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.Models.Album.RemoveImage(albumId, imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to remove image from album: %v", err))
+			return
+		}
+
+		writeAlbum(app, w, r, albumId, "Image removed from album successfully")
+	}
+}
+
+// ReorderAlbumImages - PUT /albums/:id/images/order {"image_ids": [...]}
+// lists every image of the album in its new order
+func ReorderAlbumImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageIDs, ok := readAlbumImageIDs(app, w, r)
+		if !ok {
+			return
+		}
+
+		album, ok := readAlbum(app, w, r)
+		if !ok {
+			return
+		}
+
+		err := app.Models.Album.Reorder(album.ID, imageIDs)
+		if err != nil {
+			if errors.Is(err, data.ErrOrderMismatch) {
+				app.ErrorResponse.FailedValidationResponse(w, r, map[string]string{
+					"image_ids": err.Error(),
+				})
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to reorder album: %v", err))
+			return
+		}
+
+		writeAlbum(app, w, r, album.ID, "Album reordered successfully")
+	}
+}
+
+// readAlbum loads the album named by the "id" route parameter, writing the
+// error response when it can't
+func readAlbum(app *config.Application, w http.ResponseWriter, r *http.Request) (*data.Album, bool) {
+	albumId, err := reqres.ReadIDParam(r)
+	if err != nil {
+		app.ErrorResponse.BadRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	album, err := app.Models.Album.Get(albumId)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.ErrorResponse.NotFoundResponse(w, r)
+			return nil, false
+		}
+		app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve album: %v", err))
+		return nil, false
+	}
+
+	return album, true
+}
+
+func readAlbumImageIDs(app *config.Application, w http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	var input struct {
+		ImageIDs []int64 `json:"image_ids"`
+	}
+
+	err := reqres.ReadJSON(w, r, &input)
+	if err != nil {
+		app.ErrorResponse.BadRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	v := validator.New()
+	if data.ValidateAlbumImageIDs(v, input.ImageIDs); !v.Valid() {
+		app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return input.ImageIDs, true
+}
+
+// writeAlbum responds with the current state of an album after its images
+// changed
+func writeAlbum(app *config.Application, w http.ResponseWriter, r *http.Request, albumID int64, message string) {
+	album, err := app.Models.Album.Get(albumID)
+	if err != nil {
+		app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve album: %v", err))
+		return
+	}
+
+	response := albumResponse{
+		Message: message,
+		Album:   album,
+	}
+
+	err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.ErrorResponse.ServerErrorResponse(w, r, err)
+	}
+}
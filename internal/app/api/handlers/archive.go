@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+const (
+	MaxArchiveImages = 1000
+
+	// write deadline granted per file, the server's WriteTimeout is far too
+	// short for a whole archive
+	archiveEntryWriteTimeout = 30 * time.Second
+)
+
+// archiveManifestEntry describes one image in manifest.json
+type archiveManifestEntry struct {
+	Name  string      `json:"name"`
+	Image *data.Image `json:"image"`
+}
+
+// ArchiveImages - POST /images/archive streams a zip of the images given as
+// {"ids": [...]} or, without ids, of every image matching the GET /images
+// filter and sort query parameters. Files are copied from storage one at a
+// time, stored without compression as JPEGs don't shrink, and followed by a
+// manifest.json with their metadata.
+func ArchiveImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			IDs []int64 `json:"ids"`
+		}
+
+		// the body is optional when filtering by query parameters
+		if r.ContentLength != 0 {
+			err := reqres.ReadJSON(w, r, &input)
+			if err != nil {
+				app.ErrorResponse.BadRequestResponse(w, r, err)
+				return
+			}
+		}
+
+		v := validator.New()
+
+		var images []*data.Image
+		var err error
+
+		if input.IDs != nil {
+			v.Check(len(input.IDs) > 0, "ids", "must contain at least one image id")
+			v.Check(len(input.IDs) <= MaxArchiveImages, "ids", fmt.Sprintf("must not contain more than %d image ids", MaxArchiveImages))
+			for _, id := range input.IDs {
+				v.Check(id > 0, "ids", "must only contain positive ids")
+			}
+			v.Check(len(slices.Compact(slices.Sorted(slices.Values(input.IDs)))) == len(input.IDs), "ids", "must not contain duplicate ids")
+			if !v.Valid() {
+				app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+				return
+			}
+
+			images, err = app.Models.Image.GetByIDs(input.IDs)
+			if err != nil {
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve images: %v", err))
+				return
+			}
+
+			if len(images) != len(input.IDs) {
+				app.ErrorResponse.FailedValidationResponse(w, r, map[string]string{
+					"ids": "must only contain existing image ids",
+				})
+				return
+			}
+		} else {
+			filters := readImageFilters(r.URL.Query(), v)
+			if !v.Valid() {
+				app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+				return
+			}
+
+			// one more than allowed tells whether the filters match too many
+			images, _, err = app.Models.Image.GetAll(MaxArchiveImages+1, 0, filters)
+			if err != nil {
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve images: %v", err))
+				return
+			}
+		}
+
+		if len(images) == 0 {
+			app.ErrorResponse.NotFoundResponse(w, r)
+			return
+		}
+
+		if len(images) > MaxArchiveImages {
+			app.ErrorResponse.FailedValidationResponse(w, r, map[string]string{
+				"filters": fmt.Sprintf("match more than %d images, narrow them down", MaxArchiveImages),
+			})
+			return
+		}
+
+		writeArchive(app, w, r, images)
+	}
+}
+
+// writeArchive streams the zip. Once the first byte is sent the status can't
+// change anymore, so a failure is logged and the archive left unfinished,
+// without its central directory, for the client to notice.
+func writeArchive(app *config.Application, w http.ResponseWriter, r *http.Request, images []*data.Image) {
+	ctx := r.Context()
+	rc := http.NewResponseController(w)
+
+	filename := fmt.Sprintf("images-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", reqres.ContentDisposition("attachment", filename))
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	names := map[string]bool{}
+	manifest := []*archiveManifestEntry{}
+
+	for _, image := range images {
+		rc.SetWriteDeadline(time.Now().Add(archiveEntryWriteTimeout))
+
+		obj, err := app.Storage.Open(ctx, image.Filename)
+		if err != nil {
+			app.Logger.Warn().Msgf("skipping %s in archive: %v", image.Filename, err)
+			continue
+		}
+
+		name := uniqueArchiveName(names, image.OriginalFilename)
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store,
+			Modified: image.UploadTimestamp,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, obj)
+		}
+		obj.Close()
+
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to write image archive")
+			return
+		}
+
+		manifest = append(manifest, &archiveManifestEntry{Name: name, Image: image})
+	}
+
+	rc.SetWriteDeadline(time.Now().Add(archiveEntryWriteTimeout))
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "manifest.json",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err == nil {
+		enc := json.NewEncoder(entry)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(archiveManifest{Images: manifest})
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("failed to write image archive")
+	}
+}
+
+// uniqueArchiveName makes a safe name for an entry, appending " (2)", " (3)"
+// and so on when another image had the same original filename. Names are
+// compared case-insensitively since most unzip targets are.
+func uniqueArchiveName(taken map[string]bool, originalFilename string) string {
+	name := reqres.SanitizeFilename(originalFilename)
+	if name == "" || strings.EqualFold(name, "manifest.json") {
+		name = "image.jpg"
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for n := 2; taken[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+
+	taken[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/outbox"
+)
+
+const (
+	DefaultEventsHeartbeat = 15 * time.Second
+
+	// clients wait this long before reconnecting, in milliseconds
+	eventsRetry = 3000
+	// events read per query when catching up
+	eventsReplayBatch = 100
+)
+
+// StreamEvents - GET /events streams image.created, image.updated,
+// image.deleted and image.restored as server-sent events. The id of each
+// event is its outbox id: a client reconnecting with Last-Event-ID (or
+// ?last_event_id=) first gets the events it missed, as long as the outbox
+// still has them. A comment is sent every events.heartbeat to keep proxies
+// from closing an idle stream.
+func StreamEvents(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastEventID, err := readLastEventID(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		sub, ok := app.Events.Subscribe()
+		if !ok {
+			app.ErrorResponse.ErrorResponse(w, r, http.StatusServiceUnavailable, "the server is shutting down")
+			return
+		}
+		defer sub.Close()
+
+		// the stream outlives the write timeout of the server
+		rc := http.NewResponseController(w)
+		err = rc.SetWriteDeadline(time.Time{})
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to stream events: %v", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+		if rc.Flush() != nil {
+			return
+		}
+
+		// events replayed from the outbox may be published to sub as well
+		replayed := map[int64]bool{}
+
+		for lastEventID > 0 {
+			list, err := app.Models.Outbox.ListAfter(lastEventID, nil, eventsReplayBatch)
+			if err != nil {
+				app.ErrorResponse.LogError(r, fmt.Errorf("unable to replay events: %v", err))
+				return
+			}
+
+			for _, event := range list {
+				err = writeEvent(w, outbox.NewMessage(event))
+				if err != nil {
+					return
+				}
+				replayed[event.ID] = true
+				lastEventID = event.ID
+			}
+
+			if rc.Flush() != nil || len(list) < eventsReplayBatch {
+				break
+			}
+		}
+
+		heartbeat := app.Config.Events.Heartbeat
+		if heartbeat <= 0 {
+			heartbeat = DefaultEventsHeartbeat
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg, ok := <-sub.C:
+				if !ok {
+					// dropped for falling behind, or shutting down; the
+					// client resumes from its last event
+					return
+				}
+				if replayed[msg.ID] {
+					continue
+				}
+				err = writeEvent(w, msg)
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": heartbeat\n\n")
+			}
+
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes msg as a server-sent event named after its type
+func writeEvent(w http.ResponseWriter, msg outbox.Message) error {
+	js, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Type, js)
+	return err
+}
+
+// readLastEventID reads the Last-Event-ID header browsers send when they
+// reconnect, or the last_event_id query parameter for the first connection.
+// 0 when there is neither.
+func readLastEventID(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid last event id")
+	}
+
+	return id, nil
+}
@@ -1,35 +1,44 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
-	"time"
+	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/khofesh/img-upload-view/internal/config"
 	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	middlewares "github.com/khofesh/img-upload-view/internal/middleware"
 	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/internal/validator"
 )
 
-type envelope map[string]any
-
 const (
-	MaxUploadSize = 10 << 20
-	UploadDir     = "/app/uploads"
+	MaxUploadSize = imagestore.MaxUploadSize
 )
 
 func UploadImage(app *config.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBodySize(app))
+
 		err := r.ParseMultipartForm(MaxUploadSize)
 		if err != nil {
 			app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("unable to parse form: %v", err))
 			return
 		}
 
+		// several "image" parts are handled as a batch
+		if files := r.MultipartForm.File["image"]; len(files) > 1 {
+			uploadBatch(app, w, r, files)
+			return
+		}
+
 		// get the file
 		file, fileHeader, err := r.FormFile("image")
 		if err != nil {
@@ -45,104 +54,225 @@ func UploadImage(app *config.Application) http.HandlerFunc {
 			return
 		}
 
-		// gen unique filename
-		uniqueFilename := generateUniqueFilename(fileHeader.Filename)
-
-		uploadDir := os.Getenv("UPLOAD_DIR")
-		if uploadDir == "" {
-			uploadDir = UploadDir
-		}
-
-		// NOTE: we could instead send the image data to GCP cloud storage
-		// or AWS S3 bucket
-		err = os.MkdirAll(uploadDir, 0755)
+		err = checkPresignedUpload(r, fileHeader.Size, fileHeader.Header.Get("Content-Type"))
 		if err != nil {
-			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to create upload directory: %v", err))
+			app.ErrorResponse.BadRequestResponse(w, r, err)
 			return
 		}
 
-		// destination file
-		filePath := filepath.Join(uploadDir, uniqueFilename)
-		destFile, err := os.Create(filePath)
+		imageData, err := storeImage(app, r.Context(), file, fileHeader.Filename, fileHeader.Header.Get("Content-Type"))
 		if err != nil {
-			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to create destination file: %v", err))
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
 			return
 		}
-		defer destFile.Close()
 
-		// reset file pointer
-		file.Seek(0, 0)
+		response := imageResponse{
+			Message: "Image uploaded successfully",
+			Image:   imageData,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusCreated, response, nil)
 
-		// copy
-		_, err = io.Copy(destFile, file)
 		if err != nil {
-			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to save file: %v", err))
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+func GetImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+
+		limit, err := reqres.ReadInt64(qs, "limit", 20)
+		addParamError(v, "limit", err)
+
+		offset, err := reqres.ReadInt64(qs, "offset", 0)
+		addParamError(v, "offset", err)
+
+		filters := readImageFilters(qs, v)
+
+		// "?cursor=" (even empty) switches to keyset pagination, where
+		// counting is opt-in
+		cursorMode := qs.Has("cursor")
+		count := data.CountExact
+		if cursorMode {
+			count = data.CountNone
+		}
+		count = data.CountMode(reqres.ReadString(qs, "count", string(count)))
+
+		cursor, err := data.DecodeImageCursor(qs.Get("cursor"))
+		addParamError(v, "cursor", err)
+
+		if cursorMode {
+			v.Check(offset == 0, "offset", "can not be combined with cursor")
+			v.Check(validator.PermittedValue(filters.Sort, data.CursorSorts...), "sort", "must be upload_timestamp or -upload_timestamp when paginating with a cursor")
+			v.Check(cursor == nil || cursor.Sort == filters.Sort, "cursor", "was issued for a different sort")
+			v.Check(validator.PermittedValue(count, data.CountExact, data.CountEstimate, data.CountNone), "count", "must be exact, estimate or none")
+		} else {
+			v.Check(count == data.CountExact, "count", "must be exact without a cursor")
+		}
+
+		v.Check(limit >= 1, "limit", "must be greater than zero")
+		v.Check(offset >= 0, "offset", "must not be negative")
+		if !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
 			return
 		}
 
-		// construct image metadata
-		imageData := &data.Image{
-			Filename:         uniqueFilename,
-			OriginalFilename: fileHeader.Filename,
-			URL:              fmt.Sprintf("/images/%s", uniqueFilename),
-			FileSize:         fileHeader.Size,
-			ContentType:      fileHeader.Header.Get("Content-Type"),
-			UploadTimestamp:  time.Now(),
+		if limit > 20 {
+			limit = 20
 		}
 
-		err = app.Models.Image.Insert(imageData)
-		if err != nil {
-			// delete file if error during insert
-			os.Remove(filePath)
-			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to save image metadata: %v", err))
+		if cursorMode {
+			getImagesAfter(app, w, r, limit, cursor, filters, count)
 			return
 		}
 
-		response := envelope{
-			"message": "Image uploaded successfully",
-			"image":   imageData,
+		images, totalCount, err := app.Models.Image.GetAll(limit, offset, filters)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve images: %v", err))
+			return
 		}
 
-		err = reqres.WriteJSON(w, http.StatusCreated, response, nil)
+		response := imageListResponse{
+			Images:   images,
+			Metadata: paginationMetadata(totalCount, limit, offset),
+		}
 
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
 		if err != nil {
 			app.ErrorResponse.ServerErrorResponse(w, r, err)
 		}
 	}
 }
 
-func GetImages(app *config.Application) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		limit, err := reqres.ReadLimitParam(r)
-		if err != nil {
-			app.ErrorResponse.BadRequestResponse(w, r, err)
-			return
+// readImageFilters parses and validates the filter and sort parameters of
+// GET /images, errors are added to v per parameter
+func readImageFilters(qs url.Values, v *validator.Validator) data.ImageFilters {
+	var err error
+
+	filters := data.ImageFilters{
+		ContentType: reqres.ReadString(qs, "content_type", ""),
+		Sort:        reqres.ReadString(qs, "sort", data.DefaultImageSort),
+	}
+
+	filters.MinSize, err = reqres.ReadInt64(qs, "min_size", 0)
+	addParamError(v, "min_size", err)
+
+	filters.MaxSize, err = reqres.ReadInt64(qs, "max_size", 0)
+	addParamError(v, "max_size", err)
+
+	filters.UploadedAfter, err = reqres.ReadTime(qs, "uploaded_after")
+	addParamError(v, "uploaded_after", err)
+
+	filters.UploadedBefore, err = reqres.ReadTime(qs, "uploaded_before")
+	addParamError(v, "uploaded_before", err)
+
+	for _, tag := range reqres.ReadStrings(qs, "tag") {
+		tag = data.NormalizeTag(tag)
+		if !slices.Contains(filters.Tags, tag) {
+			filters.Tags = append(filters.Tags, tag)
 		}
+	}
+
+	match := reqres.ReadString(qs, "match", "any")
+	filters.TagsMatchAll = match == "all"
+
+	v.Check(validator.PermittedValue(match, "all", "any"), "match", "must be all or any")
+	data.ValidateImageFilters(v, filters)
+
+	return filters
+}
+
+// addParamError records a parse error against the parameter it came from
+func addParamError(v *validator.Validator, key string, err error) {
+	if err != nil {
+		v.AddError(key, err.Error())
+	}
+}
+
+// readPage reads limit and offset of a paginated list, recording invalid
+// values in v. A limit above maxLimit is lowered to it.
+func readPage(qs url.Values, v *validator.Validator, defaultLimit, maxLimit int64) (limit, offset int64) {
+	limit, err := reqres.ReadInt64(qs, "limit", defaultLimit)
+	addParamError(v, "limit", err)
+
+	offset, err = reqres.ReadInt64(qs, "offset", 0)
+	addParamError(v, "offset", err)
+
+	v.Check(limit >= 1, "limit", "must be greater than zero")
+	v.Check(offset >= 0, "offset", "must not be negative")
 
-		offset, err := reqres.ReadOffsetParam(r)
+	return min(limit, maxLimit), offset
+}
+
+// getImagesAfter writes a page of GET /images in cursor mode. The metadata has
+// the cursor of the next page, null on the last one, and the total count
+// unless count=none.
+func getImagesAfter(app *config.Application, w http.ResponseWriter, r *http.Request, limit int64, cursor *data.ImageCursor, filters data.ImageFilters, count data.CountMode) {
+	images, next, err := app.Models.Image.GetAfter(limit, cursor, filters)
+	if err != nil {
+		app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve images: %v", err))
+		return
+	}
+
+	metadata := cursorMetadata{
+		Limit:   limit,
+		HasMore: next != nil,
+	}
+
+	if next != nil {
+		cursor := next.Encode()
+		metadata.NextCursor = &cursor
+	}
+
+	if count != data.CountNone {
+		totalCount, estimated, err := app.Models.Image.Count(filters, count)
 		if err != nil {
-			app.ErrorResponse.BadRequestResponse(w, r, err)
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to count images: %v", err))
 			return
 		}
+		metadata.TotalCount = &totalCount
+		metadata.TotalCountEstimated = &estimated
+	}
 
-		if limit > 20 {
-			limit = 20
+	response := imageCursorListResponse{
+		Images:   images,
+		Metadata: metadata,
+	}
+
+	err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.ErrorResponse.ServerErrorResponse(w, r, err)
+	}
+}
+
+// SearchImages - GET /images/search?q= ranks images by how well their title,
+// original filename, tags and description match q
+func SearchImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+
+		limit, offset := readPage(qs, v, 20, 20)
+
+		q := reqres.ReadString(qs, "q", "")
+
+		if data.ValidateSearchQuery(v, q); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
 		}
 
-		images, totalCount, err := app.Models.Image.GetAll(limit, offset)
+		results, totalCount, err := app.Models.Image.Search(q, limit, offset)
 		if err != nil {
-			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve images: %v", err))
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to search images: %v", err))
 			return
 		}
 
-		response := envelope{
-			"images": images,
-			"metadata": envelope{
-				"total_count": totalCount,
-				"limit":       limit,
-				"offset":      offset,
-				"has_more":    offset+limit < totalCount,
-			},
+		response := searchResponse{
+			Images:   results,
+			Metadata: paginationMetadata(totalCount, limit, offset),
 		}
 
 		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
@@ -152,6 +282,16 @@ func GetImages(app *config.Application) http.HandlerFunc {
 	}
 }
 
+// paginationMetadata is the "metadata" object of every paginated list
+func paginationMetadata(totalCount, limit, offset int64) pageMetadata {
+	return pageMetadata{
+		TotalCount: totalCount,
+		Limit:      limit,
+		Offset:     offset,
+		HasMore:    offset+limit < totalCount,
+	}
+}
+
 func GetImageByID(app *config.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		imageId, err := reqres.ReadIDParam(r)
@@ -170,18 +310,23 @@ func GetImageByID(app *config.Application) http.HandlerFunc {
 			return
 		}
 
-		response := envelope{
-			"image": image,
+		response := imageResponse{
+			Image: image,
 		}
 
-		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		headers := http.Header{"ETag": {reqres.VersionETag(image.Version)}}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, headers)
 		if err != nil {
 			app.ErrorResponse.ServerErrorResponse(w, r, err)
 		}
 	}
 }
 
-func DeleteImage(app *config.Application) http.HandlerFunc {
+// UpdateImage - PATCH /image/:id updates title, description and alt text.
+// Send the version from the ETag as If-Match to avoid overwriting changes
+// made by someone else in the meantime
+func UpdateImage(app *config.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		imageId, err := reqres.ReadIDParam(r)
 		if err != nil {
@@ -189,10 +334,15 @@ func DeleteImage(app *config.Application) http.HandlerFunc {
 			return
 		}
 
-		// get image metadata first
+		expectedVersion, checkVersion, err := reqres.ReadIfMatchVersion(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
 		image, err := app.Models.Image.GetByID(imageId)
 		if err != nil {
-			if err.Error() == "record not found" {
+			if errors.Is(err, data.ErrRecordNotFound) {
 				app.ErrorResponse.NotFoundResponse(w, r)
 				return
 			}
@@ -200,10 +350,137 @@ func DeleteImage(app *config.Application) http.HandlerFunc {
 			return
 		}
 
+		if checkVersion && image.Version != expectedVersion {
+			app.ErrorResponse.PreconditionFailedResponse(w, r)
+			return
+		}
+
+		var input struct {
+			Title       *string `json:"title"`
+			Description *string `json:"description"`
+			AltText     *string `json:"alt_text"`
+		}
+
+		err = reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			image.Title = strings.TrimSpace(*input.Title)
+		}
+		if input.Description != nil {
+			image.Description = strings.TrimSpace(*input.Description)
+		}
+		if input.AltText != nil {
+			image.AltText = strings.TrimSpace(*input.AltText)
+		}
+
+		v := validator.New()
+		if data.ValidateImageMetadata(v, image); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.Models.Image.Update(image)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.ErrorResponse.EditConflictResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to update image: %v", err))
+			return
+		}
+
+		writeSidecar(app, r.Context(), image)
+
+		response := imageResponse{
+			Message: "Image updated successfully",
+			Image:   image,
+		}
+
+		headers := http.Header{"ETag": {reqres.VersionETag(image.Version)}}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// DeleteImage - DELETE /image/:id moves the image to the trash.
+// ?permanent=true deletes the row and the file right away, admin keys only
+func DeleteImage(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		permanent, err := reqres.ReadBool(r.URL.Query(), "permanent", false)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		if permanent && !middlewares.IsAdmin(r) {
+			app.ErrorResponse.NotPermittedResponse(w, r)
+			return
+		}
+
+		// get image metadata first, a trashed image can still be deleted for good
+		getImage := app.Models.Image.GetByID
+		if permanent {
+			getImage = app.Models.Image.GetByIDWithTrashed
+		}
+
+		image, err := getImage(imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve image: %v", err))
+			return
+		}
+
+		deleted := deletedImage{
+			ID:               image.ID,
+			Filename:         image.Filename,
+			OriginalFilename: image.OriginalFilename,
+		}
+
+		if !permanent {
+			err = app.Models.Image.Trash(imageId)
+			if err != nil {
+				if errors.Is(err, data.ErrRecordNotFound) {
+					app.ErrorResponse.NotFoundResponse(w, r)
+					return
+				}
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to move image to trash: %v", err))
+				return
+			}
+
+			refreshSidecars(app, r.Context(), imageId)
+
+			response := deleteImageResponse{
+				Message:      "Image moved to trash",
+				DeletedImage: deleted,
+			}
+
+			err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+			if err != nil {
+				app.ErrorResponse.ServerErrorResponse(w, r, err)
+			}
+			return
+		}
+
 		// delete from db
 		err = app.Models.Image.Delete(imageId)
 		if err != nil {
-			if err.Error() == "record not found" {
+			if errors.Is(err, data.ErrRecordNotFound) {
 				app.ErrorResponse.NotFoundResponse(w, r)
 				return
 			}
@@ -212,25 +489,11 @@ func DeleteImage(app *config.Application) http.HandlerFunc {
 		}
 
 		// delete the physical file
-		uploadDir := os.Getenv("UPLOAD_DIR")
-		if uploadDir == "" {
-			uploadDir = UploadDir
-		}
+		deleteStoredImage(app, r.Context(), image.Filename)
 
-		filePath := filepath.Join(uploadDir, image.Filename)
-		err = os.Remove(filePath)
-		if err != nil && !os.IsNotExist(err) {
-			// maybe do some cleanup later for orphan file
-			app.Logger.Warn().Msgf("failed to delete physical file %s: %v", filePath, err)
-		}
-
-		response := envelope{
-			"message": "Image deleted successfully",
-			"deleted_image": envelope{
-				"id":                image.ID,
-				"filename":          image.Filename,
-				"original_filename": image.OriginalFilename,
-			},
+		response := deleteImageResponse{
+			Message:      "Image deleted successfully",
+			DeletedImage: deleted,
 		}
 
 		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
@@ -240,25 +503,75 @@ func DeleteImage(app *config.Application) http.HandlerFunc {
 	}
 }
 
-func generateUniqueFilename(originalFilename string) string {
-	ext := filepath.Ext(originalFilename)
-	timestamp := time.Now().Unix()
+// storeImage copies the image bytes into storage and records its metadata.
+// The stored file is removed again if the metadata can't be saved.
+func storeImage(app *config.Application, ctx context.Context, src io.Reader, originalFilename, contentType string) (*data.Image, error) {
+	imageData, err := putImage(app, ctx, src, originalFilename, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.Image.Insert(imageData)
+	if err != nil {
+		// delete file if error during insert
+		app.Storage.Delete(ctx, imageData.Filename)
+		return nil, fmt.Errorf("unable to save image metadata: %v", err)
+	}
 
-	randomBytes := make([]byte, 8)
-	rand.Read(randomBytes)
-	randomString := hex.EncodeToString(randomBytes)
+	writeSidecar(app, ctx, imageData)
 
-	return fmt.Sprintf("%d_%s%s", timestamp, randomString, ext)
+	return imageData, nil
 }
 
-func validateImageFile(size int64, contentType string) error {
-	if size > MaxUploadSize {
-		return fmt.Errorf("file size exceeds 10MB limit")
+// putImage copies the image bytes into storage under a unique filename and
+// returns the metadata to be inserted.
+func putImage(app *config.Application, ctx context.Context, src io.Reader, originalFilename, contentType string) (*data.Image, error) {
+	return imagestore.Put(ctx, app.Storage, src, originalFilename, contentType)
+}
+
+// writeSidecar keeps the JSON sidecar of image in step with its row. The
+// database stays the source of truth, so a failure is only logged.
+func writeSidecar(app *config.Application, ctx context.Context, image *data.Image) {
+	err := imagestore.WriteSidecar(ctx, app.Storage, image)
+	if err != nil {
+		app.Logger.Warn().Msgf("failed to write sidecar of image %d: %v", image.ID, err)
 	}
+}
 
-	if contentType != "image/jpeg" && contentType != "image/jpg" {
-		return fmt.Errorf("only JPEG images are allowed")
+// refreshSidecars reloads the images and rewrites their sidecars after a
+// change made in the database, e.g. tags or the trash state
+func refreshSidecars(app *config.Application, ctx context.Context, ids ...int64) {
+	for _, id := range ids {
+		image, err := app.Models.Image.GetByIDWithTrashed(id)
+		if err != nil {
+			app.Logger.Warn().Msgf("failed to refresh sidecar of image %d: %v", id, err)
+			continue
+		}
+		writeSidecar(app, ctx, image)
+	}
+}
+
+// deleteStoredImage removes the file of a permanently deleted image, its
+// sidecar and its thumbnail. The sidecar goes first so that a rebuild never
+// brings back an image whose file is gone.
+func deleteStoredImage(app *config.Application, ctx context.Context, filename string) {
+	err := imagestore.DeleteSidecar(ctx, app.Storage, filename)
+	if err != nil {
+		app.Logger.Warn().Msgf("failed to delete sidecar of %s: %v", filename, err)
 	}
 
-	return nil
+	err = imagestore.DeleteThumbnail(ctx, app.Storage, filename)
+	if err != nil {
+		app.Logger.Warn().Msgf("failed to delete thumbnail of %s: %v", filename, err)
+	}
+
+	err = app.Storage.Delete(ctx, filename)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		// maybe do some cleanup later for orphan file
+		app.Logger.Warn().Msgf("failed to delete physical file %s: %v", filename, err)
+	}
+}
+
+func validateImageFile(size int64, contentType string) error {
+	return imagestore.ValidateFile(size, contentType)
 }
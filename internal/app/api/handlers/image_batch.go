@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+const DefaultMaxBatchImages = 100
+
+// BatchImages - POST /images/batch applies one operation to many images in a
+// single transaction:
+//
+//	{"operation": "delete", "ids": [1, 2]}
+//	{"operation": "update", "ids": [1, 2], "content_type": "image/jpeg", "original_filename": "a.jpg"}
+//	{"operation": "tag", "ids": [1, 2], "tags": ["holiday"]}
+//
+// Every id gets a result, ids that don't exist are reported as not_found
+// without failing the others.
+func BatchImages(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Operation        string   `json:"operation"`
+			IDs              []int64  `json:"ids"`
+			ContentType      *string  `json:"content_type"`
+			OriginalFilename *string  `json:"original_filename"`
+			Tags             []string `json:"tags"`
+		}
+
+		err := reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		batch := &data.ImageBatch{
+			Operation:        input.Operation,
+			IDs:              input.IDs,
+			ContentType:      input.ContentType,
+			OriginalFilename: input.OriginalFilename,
+		}
+
+		for _, tag := range input.Tags {
+			batch.Tags = append(batch.Tags, data.NormalizeTag(tag))
+		}
+
+		v := validator.New()
+		if data.ValidateImageBatch(v, batch, maxBatchImages(app)); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		results, err := app.Models.Image.ApplyBatch(batch)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to apply batch: %v", err))
+			return
+		}
+
+		applied := []int64{}
+		for _, result := range results {
+			if result.Status == data.BatchStatusOK {
+				applied = append(applied, result.ID)
+			}
+		}
+
+		refreshSidecars(app, r.Context(), applied...)
+
+		response := imageBatchResponse{
+			Operation: batch.Operation,
+			Applied:   len(applied),
+			Results:   results,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+func maxBatchImages(app *config.Application) int {
+	if app.Config.Batch.MaxImages > 0 {
+		return app.Config.Batch.MaxImages
+	}
+	return DefaultMaxBatchImages
+}
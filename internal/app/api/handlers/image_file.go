@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// GetImageFile - GET /image/:id/file streams the stored bytes from the
+// storage layer, with ETag/Last-Modified validation and Range support.
+// ?download=true serves it as an attachment
+func GetImageFile(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		download := false
+		if s := reqres.ReadString(r.URL.Query(), "download", ""); s != "" {
+			download, err = strconv.ParseBool(s)
+			if err != nil {
+				app.ErrorResponse.BadRequestResponse(w, r, errors.New("invalid download parameter"))
+				return
+			}
+		}
+
+		image, err := app.Models.Image.GetByID(imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve image: %v", err))
+			return
+		}
+
+		obj, err := app.Storage.Open(r.Context(), image.Filename)
+		if err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				app.Logger.Warn().Msgf("image %d has no stored file %s", image.ID, image.Filename)
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to open image file: %v", err))
+			return
+		}
+		defer obj.Close()
+
+		disposition := "inline"
+		if download {
+			disposition = "attachment"
+		}
+
+		// the bytes behind an image ID never change
+		if app.Config.Auth.PrivateReads {
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+
+		// images stored before checksums were recorded have no ETag until
+		// cli backfill-checksums ran, Last-Modified still validates them
+		etag := ""
+		if image.Checksum != "" {
+			etag = `"` + image.Checksum + `"`
+		}
+
+		reqres.ServeFile(w, r, obj, image.ContentType, etag, image.UploadTimestamp,
+			reqres.ContentDisposition(disposition, image.OriginalFilename))
+	}
+}
+
+// GetImageThumbnail - GET /image/:id/thumbnail serves the downscaled JPEG
+// rendered by the image.thumbnail job. Until the job ran it is not found.
+func GetImageThumbnail(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		image, err := app.Models.Image.GetByID(imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve image: %v", err))
+			return
+		}
+
+		obj, err := app.Storage.Open(r.Context(), imagestore.ThumbnailKey(image.Filename))
+		if err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to open thumbnail: %v", err))
+			return
+		}
+		defer obj.Close()
+
+		// a thumbnail may be rendered again, so it is only cached briefly
+		if app.Config.Auth.PrivateReads {
+			w.Header().Set("Cache-Control", "private, max-age=3600")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		}
+
+		info := obj.Info()
+		etag := fmt.Sprintf(`"%s-%d"`, image.Checksum, imagestore.ThumbnailSize)
+		if image.Checksum == "" {
+			etag = fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size)
+		}
+
+		reqres.ServeFile(w, r, obj, "image/jpeg", etag, info.ModTime,
+			reqres.ContentDisposition("inline", image.OriginalFilename))
+	}
+}
+
+// ServeLocalImage - GET /images/<filename> serves a stored image by the
+// path in Image.URL, which nginx serves in production. Only mounted with
+// env: local; keys below the root (sidecars, thumbnails, tus chunks) are
+// not served.
+func ServeLocalImage(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/images/")
+		if key == "" || strings.ContainsAny(key, "/\\") || strings.HasPrefix(key, ".") {
+			app.ErrorResponse.NotFoundResponse(w, r)
+			return
+		}
+
+		obj, err := app.Storage.Open(r.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to open image file: %v", err))
+			return
+		}
+		defer obj.Close()
+
+		contentType := mime.TypeByExtension(path.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		reqres.ServeFile(w, r, obj, contentType, "", obj.Info().ModTime, "")
+	}
+}
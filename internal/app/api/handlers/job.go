@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// GetJobs - GET /jobs lists background jobs, newest first.
+// ?status= and ?kind= narrow the list down, e.g. ?status=dead
+func GetJobs(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+
+		limit, offset := readPage(qs, v, 20, 100)
+
+		filters := data.JobFilters{
+			Status: reqres.ReadString(qs, "status", ""),
+			Kind:   reqres.ReadString(qs, "kind", ""),
+		}
+
+		if data.ValidateJobFilters(v, filters); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		jobs, totalCount, err := app.Models.Job.GetAll(filters, limit, offset)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve jobs: %v", err))
+			return
+		}
+
+		response := jobsResponse{
+			Jobs:     jobs,
+			Metadata: paginationMetadata(totalCount, limit, offset),
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+func GetJob(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		job, err := app.Models.Job.Get(id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve job: %v", err))
+			return
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, jobResponse{Job: job}, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// RetryJob - POST /jobs/:id/retry queues a dead job again with a fresh set
+// of attempts. Jobs in any other state are a conflict.
+func RetryJob(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		job, err := app.Models.Job.Retry(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.ErrorResponse.NotFoundResponse(w, r)
+			case errors.Is(err, data.ErrJobNotDead):
+				app.ErrorResponse.ErrorResponse(w, r, http.StatusConflict, "only dead jobs can be retried")
+			default:
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retry job: %v", err))
+			}
+			return
+		}
+
+		response := jobResponse{
+			Message: "Job queued",
+			Job:     job,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusAccepted, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
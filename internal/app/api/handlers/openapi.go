@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+)
+
+// openapi.json describes every route in api.routes, the server refuses to
+// start in env local when the two disagree
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+const apiDocsPage = `<!doctype html>
+<html>
+<head>
+	<title>img-upload-view API</title>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+	<script id="api-reference" data-url="/openapi.json"></script>
+	<script src="https://cdn.jsdelivr.net/npm/@scalar/api-reference"></script>
+</body>
+</html>
+`
+
+// GetOpenAPISpec - GET /openapi.json
+func GetOpenAPISpec(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPISpec)
+	}
+}
+
+// GetAPIDocs - GET /docs renders openapi.json, mounted when
+// openapi.docsPage is set
+func GetAPIDocs(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(apiDocsPage))
+	}
+}
+
+// OpenAPIOperations lists the operations of openapi.json as "METHOD /path",
+// with path parameters in httprouter syntax, e.g. "GET /image/:id"
+func OpenAPIOperations() ([]string, error) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	operations := []string{}
+	for path, item := range spec.Paths {
+		path = strings.NewReplacer("{", ":", "}", "").Replace(path)
+
+		for method := range item {
+			// path items also hold shared parameters, summary etc.
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				operations = append(operations, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+
+	return operations, nil
+}
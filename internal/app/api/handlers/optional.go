@@ -0,0 +1,28 @@
+package handlers
+
+import "encoding/json"
+
+// optional tells a JSON field that was absent apart from one that was
+// explicitly null, e.g. to clear a nullable column in a PATCH request
+type optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+
+	if string(b) == "null" {
+		o.Value = nil
+		return nil
+	}
+
+	var value T
+	err := json.Unmarshal(b, &value)
+	if err != nil {
+		return err
+	}
+
+	o.Value = &value
+	return nil
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/presign"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+)
+
+const (
+	DefaultPresignExpiry    = 15 * time.Minute
+	DefaultPresignMaxExpiry = time.Hour
+)
+
+// presignableRoutes lists the requests a presigned URL may be issued for
+var presignableRoutes = []struct {
+	method  string
+	pattern *regexp.Regexp
+	upload  bool
+}{
+	{http.MethodPost, regexp.MustCompile(`^/upload$`), true},
+	{http.MethodGet, regexp.MustCompile(`^/image/[1-9][0-9]*$`), false},
+	{http.MethodGet, regexp.MustCompile(`^/image/[1-9][0-9]*/file$`), false},
+	// EventSource can't send an Authorization header
+	{http.MethodGet, regexp.MustCompile(`^/events$`), false},
+}
+
+// CreatePresignedURL - POST /presign issues an expiring signed URL for one
+// upload or download, so untrusted front-ends never see an API key
+func CreatePresignedURL(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.Signer == nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, errors.New("presigned URLs requested but no signing keys are configured"))
+			return
+		}
+
+		var input struct {
+			Method      string `json:"method"`
+			Path        string `json:"path"`
+			ExpiresIn   int64  `json:"expires_in"`
+			MaxSize     int64  `json:"max_size"`
+			ContentType string `json:"content_type"`
+		}
+
+		err := reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		maxExpiry := app.Config.Signing.MaxExpiry
+		if maxExpiry <= 0 {
+			maxExpiry = DefaultPresignMaxExpiry
+		}
+
+		expiresIn := DefaultPresignExpiry
+		if input.ExpiresIn != 0 {
+			expiresIn = time.Duration(input.ExpiresIn) * time.Second
+		}
+
+		errs := map[string]string{}
+
+		upload, ok := presignTarget(input.Method, input.Path)
+		if !ok {
+			errs["path"] = "must be POST /upload, GET /image/:id, GET /image/:id/file or GET /events"
+		}
+
+		if expiresIn <= 0 || expiresIn > maxExpiry {
+			errs["expires_in"] = fmt.Sprintf("must be between 1 and %d seconds", int64(maxExpiry.Seconds()))
+		}
+
+		if upload {
+			if input.MaxSize == 0 {
+				input.MaxSize = MaxUploadSize
+			}
+			if input.ContentType == "" {
+				input.ContentType = "image/jpeg"
+			}
+
+			if input.MaxSize < 1 || input.MaxSize > MaxUploadSize {
+				errs["max_size"] = fmt.Sprintf("must be between 1 and %d bytes", MaxUploadSize)
+			}
+			if validateImageFile(0, input.ContentType) != nil {
+				errs["content_type"] = "must be image/jpeg"
+			}
+		} else if ok {
+			if input.MaxSize != 0 {
+				errs["max_size"] = "only applies to uploads"
+			}
+			if input.ContentType != "" {
+				errs["content_type"] = "only applies to uploads"
+			}
+		}
+
+		if len(errs) > 0 {
+			app.ErrorResponse.FailedValidationResponse(w, r, errs)
+			return
+		}
+
+		params := presign.Params{
+			Method:      input.Method,
+			Path:        input.Path,
+			MaxSize:     input.MaxSize,
+			ContentType: input.ContentType,
+			Expires:     time.Now().Add(expiresIn).Truncate(time.Second),
+		}
+
+		response := presignResponse{
+			Presigned: presignedURL{
+				URL:         params.Path + "?" + app.Signer.Sign(params).Encode(),
+				Method:      params.Method,
+				MaxSize:     params.MaxSize,
+				ContentType: params.ContentType,
+				ExpiresAt:   params.Expires,
+			},
+		}
+
+		err = reqres.WriteJSON(w, http.StatusCreated, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// presignTarget reports whether a URL may be presigned for method and path,
+// and whether it is an upload.
+func presignTarget(method, path string) (upload bool, ok bool) {
+	for _, route := range presignableRoutes {
+		if route.method == method && route.pattern.MatchString(path) {
+			return route.upload, true
+		}
+	}
+	return false, false
+}
+
+// checkPresignedUpload enforces the size and content type a presigned upload
+// URL was issued for. Requests without a presigned URL pass.
+func checkPresignedUpload(r *http.Request, size int64, contentType string) error {
+	params := presign.ParamsFromContext(r.Context())
+	if params == nil {
+		return nil
+	}
+
+	if params.MaxSize > 0 && size > params.MaxSize {
+		return fmt.Errorf("file size exceeds the presigned limit of %d bytes", params.MaxSize)
+	}
+
+	if params.ContentType != "" && contentType != params.ContentType {
+		return fmt.Errorf("content type must be %s for this presigned URL", params.ContentType)
+	}
+
+	return nil
+}
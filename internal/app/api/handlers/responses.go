@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+)
+
+// Response bodies of the handlers. Every handler writes one of these with
+// reqres.WriteJSON, openapi.json describes the same shapes.
+
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+// imageResponse - a single image, with a message when it was changed
+type imageResponse struct {
+	Message string      `json:"message,omitempty"`
+	Image   *data.Image `json:"image"`
+}
+
+// pageMetadata - offset pagination
+type pageMetadata struct {
+	TotalCount int64 `json:"total_count"`
+	Limit      int64 `json:"limit"`
+	Offset     int64 `json:"offset"`
+	HasMore    bool  `json:"has_more"`
+}
+
+// cursorMetadata - keyset pagination of GET /images. NextCursor is null on
+// the last page, the counts are left out with count=none.
+type cursorMetadata struct {
+	Limit               int64   `json:"limit"`
+	HasMore             bool    `json:"has_more"`
+	NextCursor          *string `json:"next_cursor"`
+	TotalCount          *int64  `json:"total_count,omitempty"`
+	TotalCountEstimated *bool   `json:"total_count_estimated,omitempty"`
+}
+
+type imageListResponse struct {
+	Images   []*data.Image `json:"images"`
+	Metadata pageMetadata  `json:"metadata"`
+}
+
+type imageCursorListResponse struct {
+	Images   []*data.Image  `json:"images"`
+	Metadata cursorMetadata `json:"metadata"`
+}
+
+type searchResponse struct {
+	Images   []*data.SearchResult `json:"images"`
+	Metadata pageMetadata         `json:"metadata"`
+}
+
+type deletedImage struct {
+	ID               int64  `json:"id"`
+	Filename         string `json:"filename"`
+	OriginalFilename string `json:"original_filename"`
+}
+
+type deleteImageResponse struct {
+	Message      string       `json:"message"`
+	DeletedImage deletedImage `json:"deleted_image"`
+}
+
+type imageBatchResponse struct {
+	Operation string              `json:"operation"`
+	Applied   int                 `json:"applied"`
+	Results   []*data.BatchResult `json:"results"`
+}
+
+type uploadBatchMetadata struct {
+	Total     int  `json:"total"`
+	Succeeded int  `json:"succeeded"`
+	Failed    int  `json:"failed"`
+	Atomic    bool `json:"atomic"`
+}
+
+type uploadBatchResponse struct {
+	Results  []*uploadResult     `json:"results"`
+	Metadata uploadBatchMetadata `json:"metadata"`
+}
+
+type tagsResponse struct {
+	Tags []*data.TagCount `json:"tags"`
+}
+
+type imageTagsResponse struct {
+	ImageID int64    `json:"image_id"`
+	Tags    []string `json:"tags"`
+}
+
+type albumsResponse struct {
+	Albums []*data.Album `json:"albums"`
+}
+
+// albumResponse - a single album, with a message when it was changed
+type albumResponse struct {
+	Message string      `json:"message,omitempty"`
+	Album   *data.Album `json:"album"`
+}
+
+type albumImagesResponse struct {
+	Album    *data.Album   `json:"album"`
+	Images   []*data.Image `json:"images"`
+	Metadata pageMetadata  `json:"metadata"`
+}
+
+type presignedURL struct {
+	URL         string    `json:"url"`
+	Method      string    `json:"method"`
+	MaxSize     int64     `json:"max_size"`
+	ContentType string    `json:"content_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type presignResponse struct {
+	Presigned presignedURL `json:"presigned"`
+}
+
+// archiveManifest - manifest.json inside an archive
+type archiveManifest struct {
+	Images []*archiveManifestEntry `json:"images"`
+}
+
+type webhooksResponse struct {
+	Webhooks []*data.Webhook `json:"webhooks"`
+}
+
+// webhookResponse - a single webhook. Secret is only sent when it was
+// generated or changed, it can't be read back later.
+type webhookResponse struct {
+	Message string        `json:"message,omitempty"`
+	Webhook *data.Webhook `json:"webhook"`
+	Secret  string        `json:"secret,omitempty"`
+}
+
+type webhookDeliveriesResponse struct {
+	Deliveries []*data.WebhookDelivery `json:"deliveries"`
+	Metadata   pageMetadata            `json:"metadata"`
+}
+
+type webhookDeliveryResponse struct {
+	Message  string                `json:"message"`
+	Delivery *data.WebhookDelivery `json:"delivery"`
+}
+
+type jobsResponse struct {
+	Jobs     []*data.Job  `json:"jobs"`
+	Metadata pageMetadata `json:"metadata"`
+}
+
+type jobResponse struct {
+	Message string    `json:"message,omitempty"`
+	Job     *data.Job `json:"job"`
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// GetTags - GET /tags lists every tag with the number of images using it
+func GetTags(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags, err := app.Models.Tag.GetAllWithCounts()
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve tags: %v", err))
+			return
+		}
+
+		response := tagsResponse{
+			Tags: tags,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// AddImageTags - POST /image/:id/tags attaches {"tags": [...]} to an image
+func AddImageTags(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		var input struct {
+			Tags []string `json:"tags"`
+		}
+
+		err = reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		for i, tag := range input.Tags {
+			input.Tags[i] = data.NormalizeTag(tag)
+		}
+
+		v := validator.New()
+		if data.ValidateTags(v, input.Tags); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// make sure the image exists
+		_, err = app.Models.Image.GetByID(imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve image: %v", err))
+			return
+		}
+
+		tags, err := app.Models.Tag.AddToImage(imageId, input.Tags)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to add tags: %v", err))
+			return
+		}
+
+		refreshSidecars(app, r.Context(), imageId)
+
+		response := imageTagsResponse{
+			ImageID: imageId,
+			Tags:    tags,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// RemoveImageTag - DELETE /image/:id/tags/:tag detaches one tag from an image
+func RemoveImageTag(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		tag := data.NormalizeTag(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+
+		tags, err := app.Models.Tag.RemoveFromImage(imageId, tag)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to remove tag: %v", err))
+			return
+		}
+
+		refreshSidecars(app, r.Context(), imageId)
+
+		response := imageTagsResponse{
+			ImageID: imageId,
+			Tags:    tags,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
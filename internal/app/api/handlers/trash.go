@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// GetTrash - GET /trash lists trashed images, most recently deleted first
+func GetTrash(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		limit, offset := readPage(r.URL.Query(), v, 20, 20)
+		if !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		images, totalCount, err := app.Models.Image.GetAll(limit, offset, data.ImageFilters{Trashed: true})
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve trash: %v", err))
+			return
+		}
+
+		response := imageListResponse{
+			Images:   images,
+			Metadata: paginationMetadata(totalCount, limit, offset),
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// RestoreImage - POST /image/:id/restore takes an image out of the trash
+func RestoreImage(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imageId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.Models.Image.Restore(imageId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to restore image: %v", err))
+			return
+		}
+
+		image, err := app.Models.Image.GetByID(imageId)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve image: %v", err))
+			return
+		}
+
+		writeSidecar(app, r.Context(), image)
+
+		response := imageResponse{
+			Message: "Image restored successfully",
+			Image:   image,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
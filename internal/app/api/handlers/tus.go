@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// tus 1.0.0 core protocol with the creation and termination extensions,
+// see https://tus.io/protocols/resumable-upload
+const (
+	TusBasePath   = "/uploads/tus/"
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusChunkType  = "application/offset+octet-stream"
+
+	// a PATCH may take longer than the server's ReadTimeout on a slow link,
+	// it is only cut off when no byte arrived for this long
+	tusChunkIdleTimeout = 30 * time.Second
+)
+
+// tusLocks serializes the requests for the same upload within this process.
+// UploadModel.UpdateOffset still guards against races between replicas.
+var tusLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// TusOptions - OPTIONS /uploads/tus/ advertises the server capabilities
+func TusOptions(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(MaxUploadSize))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TusCreate - POST /uploads/tus/ creates a new upload (creation extension)
+func TusCreate(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(app, w, r) {
+			return
+		}
+
+		if r.Header.Get("Upload-Defer-Length") != "" {
+			app.ErrorResponse.BadRequestResponse(w, r, errors.New("Upload-Defer-Length is not supported"))
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			app.ErrorResponse.BadRequestResponse(w, r, errors.New("invalid Upload-Length header"))
+			return
+		}
+
+		if length > MaxUploadSize {
+			app.ErrorResponse.ErrorResponse(w, r, http.StatusRequestEntityTooLarge, "file size exceeds 10MB limit")
+			return
+		}
+
+		metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		// fail early instead of after the client has sent every byte
+		err = validateImageFile(length, tusContentType(metadata))
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		upload := &data.Upload{
+			ID:       generateUploadID(),
+			Length:   length,
+			Metadata: metadata,
+		}
+
+		err = app.Models.Upload.Insert(upload)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to create upload: %v", err))
+			return
+		}
+
+		if upload.Completed() && !completeUpload(app, w, r, upload) {
+			return
+		}
+
+		w.Header().Set("Location", TusBasePath+upload.ID)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// TusHead - HEAD /uploads/tus/:id reports how many bytes have been received
+func TusHead(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(app, w, r) {
+			return
+		}
+
+		unlock := tusLocks.Lock(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+		defer unlock()
+
+		upload, ok := readUpload(app, w, r)
+		if !ok {
+			return
+		}
+
+		// a client seeing the full offset considers the upload done
+		if upload.Completed() && upload.ImageID == nil && !completeUpload(app, w, r, upload) {
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.ImageID != nil {
+			w.Header().Set("Upload-Image-Id", strconv.FormatInt(*upload.ImageID, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// TusPatch - PATCH /uploads/tus/:id appends a chunk at Upload-Offset. Each
+// chunk is stored as its own object and the chunks are joined once the
+// upload is complete.
+func TusPatch(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(app, w, r) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != tusChunkType {
+			app.ErrorResponse.ErrorResponse(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+tusChunkType)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			app.ErrorResponse.BadRequestResponse(w, r, errors.New("invalid Upload-Offset header"))
+			return
+		}
+
+		unlock := tusLocks.Lock(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+		defer unlock()
+
+		upload, ok := readUpload(app, w, r)
+		if !ok {
+			return
+		}
+
+		if offset != upload.Offset {
+			app.ErrorResponse.ErrorResponse(w, r, http.StatusConflict, "Upload-Offset does not match the current offset")
+			return
+		}
+
+		if upload.Completed() {
+			if upload.ImageID != nil {
+				app.ErrorResponse.ErrorResponse(w, r, http.StatusForbidden, "upload is already complete")
+				return
+			}
+
+			if !completeUpload(app, w, r, upload) {
+				return
+			}
+			w.Header().Set("Upload-Image-Id", strconv.FormatInt(*upload.ImageID, 10))
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		ctx := r.Context()
+		chunkKey := tusChunkKey(upload.ID, offset)
+		body := &tusChunkReader{
+			r:  http.MaxBytesReader(w, r.Body, upload.Length-upload.Offset),
+			rc: http.NewResponseController(w),
+		}
+
+		n, err := app.Storage.Put(ctx, chunkKey, body)
+		if err == nil && n == 0 {
+			// nothing arrived, there is no chunk to keep
+			err = app.Storage.Delete(ctx, chunkKey)
+			if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+				app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to delete empty chunk: %v", err))
+				return
+			}
+		}
+		if err != nil {
+			app.Storage.Delete(ctx, chunkKey)
+
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				app.ErrorResponse.ErrorResponse(w, r, http.StatusRequestEntityTooLarge, "chunk exceeds the remaining Upload-Length")
+				return
+			}
+
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to save chunk: %v", err))
+			return
+		}
+
+		if body.err != nil {
+			// the client went away half way through, it resumes after the
+			// bytes that did arrive
+			app.Logger.Info().Msgf("upload %s interrupted after %d bytes of a chunk: %v", upload.ID, n, body.err)
+		}
+
+		if n == 0 {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		err = app.Models.Upload.UpdateOffset(upload.ID, offset, offset+n)
+		if err != nil {
+			app.Storage.Delete(ctx, chunkKey)
+
+			if errors.Is(err, data.ErrEditConflict) {
+				app.ErrorResponse.ErrorResponse(w, r, http.StatusConflict, "Upload-Offset does not match the current offset")
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to update upload offset: %v", err))
+			return
+		}
+		upload.Offset += n
+
+		if upload.Completed() {
+			if !completeUpload(app, w, r, upload) {
+				return
+			}
+			w.Header().Set("Upload-Image-Id", strconv.FormatInt(*upload.ImageID, 10))
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TusDelete - DELETE /uploads/tus/:id aborts an upload (termination extension)
+func TusDelete(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkTusResumable(app, w, r) {
+			return
+		}
+
+		// a PATCH or finalize in progress completes before the chunks go
+		unlock := tusLocks.Lock(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+		defer unlock()
+
+		upload, ok := readUpload(app, w, r)
+		if !ok {
+			return
+		}
+
+		err := deleteUploadChunks(app, r.Context(), upload.ID)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to delete upload chunks: %v", err))
+			return
+		}
+
+		err = app.Models.Upload.Delete(upload.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to delete upload: %v", err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tusChunkReader reads the body of a PATCH. Every read extends the deadlines
+// of the connection by tusChunkIdleTimeout. A read error other than the
+// chunk being too large ends the chunk early instead of failing it, so the
+// bytes received before the connection dropped are kept.
+type tusChunkReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+	// the read error that ended the chunk early
+	err error
+}
+
+func (c *tusChunkReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(tusChunkIdleTimeout)
+	c.rc.SetReadDeadline(deadline)
+	c.rc.SetWriteDeadline(deadline)
+
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		var maxBytesErr *http.MaxBytesError
+		if !errors.As(err, &maxBytesErr) {
+			c.err = err
+			return n, io.EOF
+		}
+	}
+
+	return n, err
+}
+
+type uploadValidationError struct {
+	err error
+}
+
+func (e *uploadValidationError) Error() string {
+	return e.err.Error()
+}
+
+// finalizeUpload joins the stored chunks and runs them through the same
+// validation and storeImage pipeline as UploadImage.
+func finalizeUpload(app *config.Application, ctx context.Context, upload *data.Upload) (*data.Image, error) {
+	contentType := tusContentType(upload.Metadata)
+
+	err := validateImageFile(upload.Length, contentType)
+	if err != nil {
+		return nil, &uploadValidationError{err: err}
+	}
+
+	keys, err := app.Storage.List(ctx, tusChunkPrefix(upload.ID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list upload chunks: %v", err)
+	}
+
+	readers := []io.Reader{}
+	var expectedOffset int64
+	for _, key := range keys {
+		chunkOffset, err := strconv.ParseInt(path.Base(key), 10, 64)
+		if err != nil || chunkOffset != expectedOffset {
+			return nil, fmt.Errorf("upload %s has a missing or stray chunk at %s", upload.ID, key)
+		}
+
+		obj, err := app.Storage.Open(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open upload chunk: %v", err)
+		}
+		defer obj.Close()
+
+		readers = append(readers, obj)
+		expectedOffset += obj.Info().Size
+	}
+
+	if expectedOffset != upload.Length {
+		return nil, fmt.Errorf("upload %s has %d bytes stored, expected %d", upload.ID, expectedOffset, upload.Length)
+	}
+
+	filename := upload.Metadata["filename"]
+	if filename == "" {
+		filename = upload.ID
+	}
+
+	image, err := putImage(app, ctx, io.MultiReader(readers...), filename, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.Upload.Complete(upload.ID, image)
+	if err != nil {
+		app.Storage.Delete(ctx, image.Filename)
+
+		if !errors.Is(err, data.ErrEditConflict) {
+			return nil, fmt.Errorf("unable to complete upload: %v", err)
+		}
+
+		// finalized by another request in the meantime
+		return completedUploadImage(app, upload.ID)
+	}
+
+	writeSidecar(app, ctx, image)
+
+	err = deleteUploadChunks(app, ctx, upload.ID)
+	if err != nil {
+		app.Logger.Warn().Msgf("failed to delete chunks of upload %s: %v", upload.ID, err)
+	}
+
+	return image, nil
+}
+
+// completedUploadImage returns the image an upload was completed with
+func completedUploadImage(app *config.Application, uploadID string) (*data.Image, error) {
+	upload, err := app.Models.Upload.Get(uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve upload: %v", err)
+	}
+	if upload.ImageID == nil {
+		return nil, fmt.Errorf("upload %s has no image", uploadID)
+	}
+
+	image, err := app.Models.Image.GetByIDWithTrashed(*upload.ImageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve image of upload: %v", err)
+	}
+
+	return image, nil
+}
+
+// completeUpload finalizes an upload that received every byte, writing an
+// error response when that fails. PATCH and HEAD also use it to resume an
+// upload left without its image, e.g. by a server that stopped in between.
+func completeUpload(app *config.Application, w http.ResponseWriter, r *http.Request, upload *data.Upload) bool {
+	image, err := finalizeUpload(app, r.Context(), upload)
+	if err != nil {
+		var validationErr *uploadValidationError
+		if errors.As(err, &validationErr) {
+			app.ErrorResponse.BadRequestResponse(w, r, validationErr.err)
+			return false
+		}
+		app.ErrorResponse.ServerErrorResponse(w, r, err)
+		return false
+	}
+
+	upload.ImageID = &image.ID
+	return true
+}
+
+func deleteUploadChunks(app *config.Application, ctx context.Context, uploadID string) error {
+	keys, err := app.Storage.List(ctx, tusChunkPrefix(uploadID))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err = app.Storage.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readUpload loads the upload named by the "id" route parameter, writing a
+// 404 response when it does not exist.
+func readUpload(app *config.Application, w http.ResponseWriter, r *http.Request) (*data.Upload, bool) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	upload, err := app.Models.Upload.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.ErrorResponse.NotFoundResponse(w, r)
+			return nil, false
+		}
+		app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve upload: %v", err))
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// checkTusResumable sets the Tus-Resumable response header and rejects
+// clients speaking another protocol version.
+func checkTusResumable(app *config.Application, w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		app.ErrorResponse.ErrorResponse(w, r, http.StatusPreconditionFailed, "unsupported tus protocol version")
+		return false
+	}
+
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, a comma separated list
+// of "key base64(value)" pairs.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// tusContentType reads the content type from the metadata keys used by the
+// common tus clients.
+func tusContentType(metadata map[string]string) string {
+	if contentType := metadata["filetype"]; contentType != "" {
+		return contentType
+	}
+	return metadata["content_type"]
+}
+
+func tusChunkPrefix(uploadID string) string {
+	return "tus/" + uploadID + "/"
+}
+
+// tusChunkKey zero pads the offset so List returns chunks in upload order.
+func tusChunkKey(uploadID string, offset int64) string {
+	return fmt.Sprintf("%s%020d", tusChunkPrefix(uploadID), offset)
+}
+
+func generateUploadID() string {
+	randomBytes := make([]byte, 16)
+	rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock acquires the lock for key and returns the function releasing it.
+// Locks are dropped from the map once nobody holds or waits for them.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		k.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
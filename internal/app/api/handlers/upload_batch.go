@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+)
+
+const (
+	DefaultMaxBatchFiles    = 20
+	DefaultBatchConcurrency = 4
+)
+
+// uploadResult is the outcome for one file of a batch upload
+type uploadResult struct {
+	Index    int         `json:"index"`
+	Filename string      `json:"filename"`
+	Status   int         `json:"status"`
+	Image    *data.Image `json:"image,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// uploadBatch stores several "image" parts of one multipart request. Every
+// file gets its own result and the response is 207 Multi-Status. With
+// ?atomic=true the rows are inserted in one transaction and nothing is kept
+// unless every file succeeds.
+func uploadBatch(app *config.Application, w http.ResponseWriter, r *http.Request, files []*multipart.FileHeader) {
+	if len(files) > maxBatchFiles(app) {
+		app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("at most %d files can be uploaded at once", maxBatchFiles(app)))
+		return
+	}
+
+	atomic := false
+	if s := reqres.ReadString(r.URL.Query(), "atomic", ""); s != "" {
+		var err error
+		atomic, err = strconv.ParseBool(s)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("invalid atomic parameter"))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	results := make([]*uploadResult, len(files))
+	for i, fileHeader := range files {
+		results[i] = &uploadResult{Index: i, Filename: fileHeader.Filename}
+
+		err := validateImageFile(fileHeader.Size, fileHeader.Header.Get("Content-Type"))
+		if err == nil {
+			err = checkPresignedUpload(r, fileHeader.Size, fileHeader.Header.Get("Content-Type"))
+		}
+		if err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+		}
+	}
+
+	if atomic && countFailed(results) > 0 {
+		writeBatchResponse(app, w, r, results, true)
+		return
+	}
+
+	// in atomic mode only the files are written here, the rows follow below
+	var store imageStoreFunc = storeImage
+	if atomic {
+		store = putImage
+	}
+
+	sem := make(chan struct{}, batchConcurrency(app))
+	var wg sync.WaitGroup
+
+	for i, fileHeader := range files {
+		if results[i].Error != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *uploadResult, fileHeader *multipart.FileHeader) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			image, err := storeBatchFile(app, ctx, fileHeader, store)
+			if err != nil {
+				app.ErrorResponse.LogError(r, err)
+				result.Status = http.StatusInternalServerError
+				result.Error = "the server encountered a problem and could not process this file"
+				return
+			}
+
+			result.Status = http.StatusCreated
+			result.Image = image
+		}(results[i], fileHeader)
+	}
+
+	wg.Wait()
+
+	if atomic {
+		insertBatchAtomically(app, ctx, r, results)
+	}
+
+	writeBatchResponse(app, w, r, results, atomic)
+}
+
+// imageStoreFunc is either storeImage or putImage
+type imageStoreFunc func(app *config.Application, ctx context.Context, src io.Reader, originalFilename, contentType string) (*data.Image, error)
+
+func storeBatchFile(app *config.Application, ctx context.Context, fileHeader *multipart.FileHeader, store imageStoreFunc) (*data.Image, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open image file: %v", err)
+	}
+	defer file.Close()
+
+	return store(app, ctx, file, fileHeader.Filename, fileHeader.Header.Get("Content-Type"))
+}
+
+// insertBatchAtomically inserts the rows for files that were written by
+// putImage. If a file or the insert failed, every stored file is removed
+// again.
+func insertBatchAtomically(app *config.Application, ctx context.Context, r *http.Request, results []*uploadResult) {
+	if countFailed(results) == 0 {
+		images := make([]*data.Image, len(results))
+		for i, result := range results {
+			images[i] = result.Image
+		}
+
+		err := app.Models.Image.InsertMany(images)
+		if err == nil {
+			for _, image := range images {
+				writeSidecar(app, ctx, image)
+			}
+			return
+		}
+
+		app.ErrorResponse.LogError(r, fmt.Errorf("unable to save image metadata: %v", err))
+		for _, result := range results {
+			result.Status = http.StatusInternalServerError
+			result.Error = "the server encountered a problem and could not save the batch"
+		}
+	}
+
+	for _, result := range results {
+		if result.Image != nil {
+			app.Storage.Delete(ctx, result.Image.Filename)
+			result.Image = nil
+		}
+	}
+}
+
+// writeBatchResponse writes 207 Multi-Status with one entry per file. A failed
+// atomic batch is reported as a whole since nothing was stored: 422 when a
+// file was invalid, 500 otherwise.
+func writeBatchResponse(app *config.Application, w http.ResponseWriter, r *http.Request, results []*uploadResult, atomic bool) {
+	failed := countFailed(results)
+	status := http.StatusMultiStatus
+
+	if atomic && failed > 0 {
+		status = http.StatusInternalServerError
+		for _, result := range results {
+			switch {
+			case result.Status == http.StatusBadRequest:
+				status = http.StatusUnprocessableEntity
+			case result.Error == "":
+				result.Status = http.StatusFailedDependency
+				result.Error = "not stored because another file in the batch failed"
+			}
+		}
+		failed = len(results)
+	}
+
+	response := uploadBatchResponse{
+		Results: results,
+		Metadata: uploadBatchMetadata{
+			Total:     len(results),
+			Succeeded: len(results) - failed,
+			Failed:    failed,
+			Atomic:    atomic,
+		},
+	}
+
+	err := reqres.WriteJSON(w, status, response, nil)
+	if err != nil {
+		app.ErrorResponse.ServerErrorResponse(w, r, err)
+	}
+}
+
+func countFailed(results []*uploadResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// maxUploadBodySize bounds the request body so a batch can't exceed the
+// per-file limit times the number of files it may contain.
+func maxUploadBodySize(app *config.Application) int64 {
+	// extra megabyte for the multipart boundaries and part headers
+	return int64(MaxUploadSize)*int64(maxBatchFiles(app)) + 1<<20
+}
+
+func maxBatchFiles(app *config.Application) int {
+	if app.Config.Upload.MaxBatchFiles > 0 {
+		return app.Config.Upload.MaxBatchFiles
+	}
+	return DefaultMaxBatchFiles
+}
+
+func batchConcurrency(app *config.Application) int {
+	if app.Config.Upload.BatchConcurrency > 0 {
+		return app.Config.Upload.BatchConcurrency
+	}
+	return DefaultBatchConcurrency
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/pkg/safefetch"
+)
+
+const (
+	DefaultRemoteImportTimeout      = 8 * time.Second
+	DefaultRemoteImportMaxRedirects = 3
+)
+
+// UploadImageFromURL - POST /upload/url fetches {"url": ...} server side and
+// stores it the same way as UploadImage
+func UploadImageFromURL(app *config.Application) http.HandlerFunc {
+	fetcher := newRemoteFetcher(app)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			URL string `json:"url"`
+		}
+
+		err := reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		u, err := url.Parse(input.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			app.ErrorResponse.FailedValidationResponse(w, r, map[string]string{
+				"url": "must be an absolute http or https URL",
+			})
+			return
+		}
+
+		remote, err := fetcher.Fetch(r.Context(), u.String())
+		if err != nil {
+			switch {
+			case errors.Is(err, safefetch.ErrTooLarge):
+				app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("file size exceeds 10MB limit"))
+			case errors.Is(err, safefetch.ErrDisallowedAddress):
+				app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("url points to a private or reserved network address"))
+			case errors.Is(err, safefetch.ErrDisallowedContentType):
+				app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("only JPEG images are allowed"))
+			default:
+				app.ErrorResponse.BadRequestResponse(w, r, fmt.Errorf("unable to fetch image: %v", err))
+			}
+			return
+		}
+
+		// validate
+		err = validateImageFile(int64(len(remote.Body)), remote.ContentType)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		imageData, err := storeImage(app, r.Context(), bytes.NewReader(remote.Body), remoteFilename(remote.FinalURL), remote.ContentType)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+			return
+		}
+
+		response := imageResponse{
+			Message: "Image uploaded successfully",
+			Image:   imageData,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusCreated, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+func newRemoteFetcher(app *config.Application) *safefetch.Fetcher {
+	opts := safefetch.Options{
+		MaxSize:              MaxUploadSize,
+		Timeout:              app.Config.RemoteImport.Timeout,
+		MaxRedirects:         app.Config.RemoteImport.MaxRedirects,
+		AllowedContentTypes:  []string{"image/jpeg", "image/jpg"},
+		AllowPrivateNetworks: app.Config.RemoteImport.AllowPrivateNetworks,
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultRemoteImportTimeout
+	}
+
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = DefaultRemoteImportMaxRedirects
+	}
+
+	return safefetch.New(opts)
+}
+
+// remoteFilename derives the original filename from the last path segment of
+// the URL the image was served from.
+func remoteFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "image"
+	}
+
+	if path.Ext(name) == "" {
+		name += ".jpg"
+	}
+
+	return name
+}
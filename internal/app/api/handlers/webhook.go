@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/reqres"
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/khofesh/img-upload-view/internal/webhook"
+)
+
+// GetWebhooks - GET /webhooks
+func GetWebhooks(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhooks, err := app.Models.Webhook.GetAll()
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve webhooks: %v", err))
+			return
+		}
+
+		response := webhooksResponse{
+			Webhooks: webhooks,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// CreateWebhook - POST /webhooks {"url": ..., "events": [...], "secret": ...}.
+// Without a secret one is generated, either way it is returned once.
+func CreateWebhook(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Secret string   `json:"secret"`
+			Active *bool    `json:"active"`
+		}
+
+		err := reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		hook := &data.Webhook{
+			URL:    strings.TrimSpace(input.URL),
+			Events: input.Events,
+			Secret: input.Secret,
+			Active: true,
+		}
+
+		if input.Active != nil {
+			hook.Active = *input.Active
+		}
+		if hook.Secret == "" {
+			hook.Secret = webhook.NewSecret()
+		}
+
+		v := validator.New()
+		if data.ValidateWebhook(v, hook, app.Config.Webhooks.AllowPrivateNetworks); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.Models.Webhook.Insert(hook)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to create webhook: %v", err))
+			return
+		}
+
+		response := webhookResponse{
+			Message: "Webhook created successfully",
+			Webhook: hook,
+			Secret:  hook.Secret,
+		}
+
+		headers := http.Header{"Location": {fmt.Sprintf("/webhooks/%d", hook.ID)}}
+
+		err = reqres.WriteJSON(w, http.StatusCreated, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// GetWebhook - GET /webhooks/:id
+func GetWebhook(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := readWebhook(app, w, r)
+		if !ok {
+			return
+		}
+
+		response := webhookResponse{
+			Webhook: hook,
+		}
+
+		headers := http.Header{"ETag": {reqres.VersionETag(hook.Version)}}
+
+		err := reqres.WriteJSON(w, http.StatusOK, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// UpdateWebhook - PATCH /webhooks/:id changes the url, events, secret or
+// active flag. "secret": "" generates a new secret.
+func UpdateWebhook(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expectedVersion, checkVersion, err := reqres.ReadIfMatchVersion(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		hook, ok := readWebhook(app, w, r)
+		if !ok {
+			return
+		}
+
+		if checkVersion && hook.Version != expectedVersion {
+			app.ErrorResponse.PreconditionFailedResponse(w, r)
+			return
+		}
+
+		var input struct {
+			URL    *string   `json:"url"`
+			Events *[]string `json:"events"`
+			Secret *string   `json:"secret"`
+			Active *bool     `json:"active"`
+		}
+
+		err = reqres.ReadJSON(w, r, &input)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		if input.URL != nil {
+			hook.URL = strings.TrimSpace(*input.URL)
+		}
+		if input.Events != nil {
+			hook.Events = *input.Events
+		}
+		if input.Active != nil {
+			hook.Active = *input.Active
+		}
+
+		secret := ""
+		if input.Secret != nil {
+			secret = *input.Secret
+			if secret == "" {
+				secret = webhook.NewSecret()
+			}
+			hook.Secret = secret
+		}
+
+		v := validator.New()
+		if data.ValidateWebhook(v, hook, app.Config.Webhooks.AllowPrivateNetworks); !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.Models.Webhook.Update(hook)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				app.ErrorResponse.EditConflictResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to update webhook: %v", err))
+			return
+		}
+
+		response := webhookResponse{
+			Message: "Webhook updated successfully",
+			Webhook: hook,
+			Secret:  secret,
+		}
+
+		headers := http.Header{"ETag": {reqres.VersionETag(hook.Version)}}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, headers)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// DeleteWebhook - DELETE /webhooks/:id removes the webhook with its
+// delivery log, pending deliveries are dropped
+func DeleteWebhook(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.Models.Webhook.Delete(webhookId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to delete webhook: %v", err))
+			return
+		}
+
+		response := messageResponse{
+			Message: "Webhook deleted successfully",
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// GetWebhookDeliveries - GET /webhooks/:id/deliveries lists the delivery
+// log of a webhook, newest first
+func GetWebhookDeliveries(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		limit, offset := readPage(r.URL.Query(), v, 20, 100)
+		if !v.Valid() {
+			app.ErrorResponse.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		hook, ok := readWebhook(app, w, r)
+		if !ok {
+			return
+		}
+
+		deliveries, totalCount, err := app.Models.Webhook.GetDeliveries(hook.ID, limit, offset)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve webhook deliveries: %v", err))
+			return
+		}
+
+		response := webhookDeliveriesResponse{
+			Deliveries: deliveries,
+			Metadata:   paginationMetadata(totalCount, limit, offset),
+		}
+
+		err = reqres.WriteJSON(w, http.StatusOK, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// RedeliverWebhookDelivery - POST /webhooks/:id/deliveries/:delivery_id/redeliver
+// queues the payload of a past delivery again as a new delivery
+func RedeliverWebhookDelivery(app *config.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookId, err := reqres.ReadIDParam(r)
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		deliveryId, err := reqres.ReadNamedIDParam(r, "delivery_id")
+		if err != nil {
+			app.ErrorResponse.BadRequestResponse(w, r, err)
+			return
+		}
+
+		delivery, err := app.Models.Webhook.Redeliver(webhookId, deliveryId)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.ErrorResponse.NotFoundResponse(w, r)
+				return
+			}
+			app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to redeliver webhook delivery: %v", err))
+			return
+		}
+
+		response := webhookDeliveryResponse{
+			Message:  "Delivery queued",
+			Delivery: delivery,
+		}
+
+		err = reqres.WriteJSON(w, http.StatusAccepted, response, nil)
+		if err != nil {
+			app.ErrorResponse.ServerErrorResponse(w, r, err)
+		}
+	}
+}
+
+// readWebhook loads the webhook named by the "id" route parameter, writing
+// the error response when it can't
+func readWebhook(app *config.Application, w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	webhookId, err := reqres.ReadIDParam(r)
+	if err != nil {
+		app.ErrorResponse.BadRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	hook, err := app.Models.Webhook.Get(webhookId)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.ErrorResponse.NotFoundResponse(w, r)
+			return nil, false
+		}
+		app.ErrorResponse.ServerErrorResponse(w, r, fmt.Errorf("unable to retrieve webhook: %v", err))
+		return nil, false
+	}
+
+	return hook, true
+}
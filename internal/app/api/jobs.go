@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/jobs"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+const (
+	DefaultJobWorkers      = 2
+	DefaultJobPollInterval = time.Second
+	DefaultJobTimeout      = 5 * time.Minute
+	DefaultJobRetryBackoff = 10 * time.Second
+	DefaultJobRetention    = 7 * 24 * time.Hour
+
+	maxJobBackoff    = time.Hour
+	jobCleanupEvery  = time.Hour
+	jobLeaseOverhead = time.Minute
+)
+
+// jobHandlers registers the handler of every kind of job
+func jobHandlers(app *config.Application) *jobs.Registry {
+	registry := jobs.NewRegistry()
+
+	jobs.Register(registry, jobs.ImageThumbnail, func(ctx context.Context, payload jobs.ImagePayload) error {
+		image, err := app.Models.Image.GetByIDWithTrashed(payload.ImageID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				// deleted in the meantime, nothing to do
+				return nil
+			}
+			return err
+		}
+
+		err = imagestore.WriteThumbnail(ctx, app.Storage, image.Filename)
+		if errors.Is(err, storage.ErrObjectNotFound) || errors.Is(err, imagestore.ErrTooManyPixels) {
+			return jobs.Permanent(err)
+		}
+		return err
+	})
+
+	return registry
+}
+
+// runJobWorkers runs jobs.workers workers until ctx is done, and deletes
+// succeeded jobs after jobs.retention. A job interrupted by ctx is queued
+// again for the next start.
+func runJobWorkers(ctx context.Context, app *config.Application, registry *jobs.Registry) {
+	workers := app.Config.Jobs.Workers
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runJobWorker(ctx, app, registry)
+		}()
+	}
+
+	retention := app.Config.Jobs.Retention
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+
+	ticker := time.NewTicker(jobCleanupEvery)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.Models.Job.DeleteFinished(time.Now().Add(-retention))
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to delete finished jobs")
+		} else if deleted > 0 {
+			app.Logger.Info().Int64("deleted", deleted).Msg("deleted finished jobs")
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runJobWorker(ctx context.Context, app *config.Application, registry *jobs.Registry) {
+	interval := app.Config.Jobs.PollInterval
+	if interval <= 0 {
+		interval = DefaultJobPollInterval
+	}
+
+	timeout := app.Config.Jobs.Timeout
+	if timeout <= 0 {
+		timeout = DefaultJobTimeout
+	}
+
+	kinds := registry.Kinds()
+
+	for ctx.Err() == nil {
+		job, err := app.Models.Job.Claim(kinds, timeout+jobLeaseOverhead)
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to claim job")
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		runJob(ctx, app, registry, job, timeout)
+	}
+}
+
+func runJob(ctx context.Context, app *config.Application, registry *jobs.Registry, job *data.Job, timeout time.Duration) {
+	logger := app.Logger.With().Int64("job_id", job.ID).Str("kind", job.Kind).Int("attempt", job.Attempts).Logger()
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// its workers kept dying before they could record the outcome
+		err = jobs.Permanent(errors.New("lease expired on every attempt"))
+	} else {
+		jobCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = runJobHandler(jobCtx, registry, job)
+		cancel()
+	}
+
+	if err == nil {
+		err = app.Models.Job.Complete(job.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to complete job")
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		err = app.Models.Job.Release(job.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to release job")
+		}
+		return
+	}
+
+	retryBackoff := app.Config.Jobs.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultJobRetryBackoff
+	}
+
+	job.LastError = err.Error()
+	if jobs.IsPermanent(err) || job.Attempts >= job.MaxAttempts {
+		job.Status = data.JobDead
+		logger.Error().Err(err).Msg("job failed for good")
+	} else {
+		job.Status = data.JobQueued
+		job.RunAt = time.Now().Add(backoff(job.Attempts, retryBackoff, maxJobBackoff))
+		logger.Warn().Err(err).Time("retry_at", job.RunAt).Msg("job failed")
+	}
+
+	err = app.Models.Job.Fail(job)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to record job failure")
+	}
+}
+
+// runJobHandler runs the handler of job, turning a panic into an error
+func runJobHandler(ctx context.Context, registry *jobs.Registry, job *data.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return registry.Run(ctx, job)
+}
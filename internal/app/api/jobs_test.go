@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/jobs"
+	"github.com/rs/zerolog"
+)
+
+// fakeJobs records how runJob settles a job
+type fakeJobs struct {
+	data.IJobModel
+
+	completed []int64
+	released  []int64
+	failed    []data.Job
+}
+
+func (m *fakeJobs) Complete(id int64) error {
+	m.completed = append(m.completed, id)
+	return nil
+}
+
+func (m *fakeJobs) Release(id int64) error {
+	m.released = append(m.released, id)
+	return nil
+}
+
+func (m *fakeJobs) Fail(job *data.Job) error {
+	m.failed = append(m.failed, *job)
+	return nil
+}
+
+func TestRunJob(t *testing.T) {
+	const retryBackoff = 10 * time.Second
+
+	testKind := jobs.Kind[jobs.ImagePayload]("test.kind")
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name     string
+		kind     string
+		attempts int
+		handler  func(ctx context.Context, payload jobs.ImagePayload) error
+		// cancel the worker context while the handler runs
+		shutdown bool
+
+		wantStatus string
+		wantError  string
+		// the retry delay of a queued job
+		wantBackoff time.Duration
+		wantRun     bool
+	}{
+		{
+			name:       "succeeds",
+			attempts:   1,
+			handler:    func(context.Context, jobs.ImagePayload) error { return nil },
+			wantStatus: data.JobSucceeded,
+			wantRun:    true,
+		},
+		{
+			name:        "fails, queued again",
+			attempts:    1,
+			handler:     func(context.Context, jobs.ImagePayload) error { return errBoom },
+			wantStatus:  data.JobQueued,
+			wantError:   "boom",
+			wantBackoff: retryBackoff,
+			wantRun:     true,
+		},
+		{
+			name:        "fails again, backing off",
+			attempts:    3,
+			handler:     func(context.Context, jobs.ImagePayload) error { return errBoom },
+			wantStatus:  data.JobQueued,
+			wantError:   "boom",
+			wantBackoff: 4 * retryBackoff,
+			wantRun:     true,
+		},
+		{
+			name:       "fails on the last attempt",
+			attempts:   data.DefaultJobMaxAttempts,
+			handler:    func(context.Context, jobs.ImagePayload) error { return errBoom },
+			wantStatus: data.JobDead,
+			wantError:  "boom",
+			wantRun:    true,
+		},
+		{
+			name:       "fails for good",
+			attempts:   1,
+			handler:    func(context.Context, jobs.ImagePayload) error { return jobs.Permanent(errBoom) },
+			wantStatus: data.JobDead,
+			wantError:  "boom",
+			wantRun:    true,
+		},
+		{
+			name:        "panics",
+			attempts:    1,
+			handler:     func(context.Context, jobs.ImagePayload) error { panic("oops") },
+			wantStatus:  data.JobQueued,
+			wantError:   "panic: oops",
+			wantBackoff: retryBackoff,
+			wantRun:     true,
+		},
+		{
+			name:       "lease expired on every attempt",
+			attempts:   data.DefaultJobMaxAttempts + 1,
+			handler:    func(context.Context, jobs.ImagePayload) error { return nil },
+			wantStatus: data.JobDead,
+			wantError:  "lease expired on every attempt",
+		},
+		{
+			name:       "unknown kind",
+			kind:       "other.kind",
+			attempts:   1,
+			handler:    func(context.Context, jobs.ImagePayload) error { return nil },
+			wantStatus: data.JobDead,
+			wantError:  `no handler for jobs of kind "other.kind"`,
+		},
+		{
+			name:     "interrupted by the shutdown",
+			attempts: 1,
+			handler: func(ctx context.Context, _ jobs.ImagePayload) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			shutdown:   true,
+			wantStatus: data.JobRunning,
+			wantRun:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		logger := zerolog.Nop()
+		cfg := &config.Config{}
+		cfg.Jobs.RetryBackoff = retryBackoff
+
+		model := &fakeJobs{}
+		app := &config.Application{Logger: &logger, Config: cfg, Models: data.Models{Job: model}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		ran := false
+		registry := jobs.NewRegistry()
+		jobs.Register(registry, testKind, func(jobCtx context.Context, payload jobs.ImagePayload) error {
+			ran = true
+			if payload.ImageID != 7 {
+				t.Errorf("%s: got payload %+v", tt.name, payload)
+			}
+			if tt.shutdown {
+				cancel()
+			}
+			return tt.handler(jobCtx, payload)
+		})
+
+		kind := tt.kind
+		if kind == "" {
+			kind = string(testKind)
+		}
+		payload, _ := json.Marshal(jobs.ImagePayload{ImageID: 7})
+
+		job := &data.Job{
+			ID:          1,
+			Kind:        kind,
+			Payload:     payload,
+			Status:      data.JobRunning,
+			Attempts:    tt.attempts,
+			MaxAttempts: data.DefaultJobMaxAttempts,
+		}
+
+		start := time.Now()
+		runJob(ctx, app, registry, job, time.Minute)
+		cancel()
+
+		if ran != tt.wantRun {
+			t.Errorf("%s: handler ran %v, want %v", tt.name, ran, tt.wantRun)
+		}
+
+		status := data.JobRunning
+		switch {
+		case len(model.completed) > 0:
+			status = data.JobSucceeded
+		case len(model.failed) > 0:
+			status = model.failed[0].Status
+		case len(model.released) == 0:
+			t.Errorf("%s: job was neither completed, failed nor released", tt.name)
+		}
+
+		if status != tt.wantStatus {
+			t.Errorf("%s: got status %s, want %s", tt.name, status, tt.wantStatus)
+			continue
+		}
+		if len(model.failed) == 0 {
+			continue
+		}
+
+		failed := model.failed[0]
+		if !strings.Contains(failed.LastError, tt.wantError) {
+			t.Errorf("%s: got error %q, want %q", tt.name, failed.LastError, tt.wantError)
+		}
+		if tt.wantBackoff > 0 {
+			delay := failed.RunAt.Sub(start)
+			if delay < tt.wantBackoff || delay > tt.wantBackoff+time.Second {
+				t.Errorf("%s: retried after %v, want %v", tt.name, delay, tt.wantBackoff)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/outbox"
+)
+
+const (
+	DefaultOutboxPollInterval = time.Second
+	DefaultOutboxMaxBackoff   = 5 * time.Minute
+	DefaultOutboxRetention    = 7 * 24 * time.Hour
+
+	outboxInitialBackoff = time.Second
+	outboxCleanupEvery   = time.Hour
+
+	// events claimed per query
+	outboxBatchSize = 100
+	// claimed events are left alone by other instances for this long
+	outboxLease = time.Minute
+)
+
+// outboxSinks builds the sinks named in outbox.sinks
+func outboxSinks(app *config.Application) ([]outbox.Sink, error) {
+	names := app.Config.Outbox.Sinks
+	if len(names) == 0 {
+		names = []string{"webhooks"}
+	}
+
+	sinks := []outbox.Sink{}
+	for _, name := range names {
+		switch name {
+		case "webhooks":
+			sinks = append(sinks, outbox.WebhookSink{Webhooks: app.Models.Webhook})
+		case "log":
+			sinks = append(sinks, outbox.LogSink{Logger: app.Logger})
+		case "nats":
+			cfg := app.Config.Outbox.NATS
+			sink, err := outbox.NewNATSSink(cfg.URL, cfg.SubjectPrefix, cfg.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q, must be webhooks, log or nats", name)
+		}
+	}
+
+	return sinks, nil
+}
+
+// runOutboxDispatcher publishes the events of the outbox to every sink,
+// every outbox.pollInterval until ctx is done. An event is marked published
+// once all sinks accepted it, otherwise it is retried with backoff, sinks
+// that took it already included.
+func runOutboxDispatcher(ctx context.Context, app *config.Application, sinks []outbox.Sink) {
+	defer func() {
+		for _, sink := range sinks {
+			if closer, ok := sink.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+	}()
+
+	interval := app.Config.Outbox.PollInterval
+	if interval <= 0 {
+		interval = DefaultOutboxPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Time{}
+
+	for {
+		dispatchOutbox(ctx, app, sinks)
+
+		if time.Since(lastCleanup) >= outboxCleanupEvery {
+			cleanupOutbox(app)
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func dispatchOutbox(ctx context.Context, app *config.Application, sinks []outbox.Sink) {
+	maxBackoff := app.Config.Outbox.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultOutboxMaxBackoff
+	}
+
+	for ctx.Err() == nil {
+		events, err := app.Models.Outbox.Claim(outboxBatchSize, outboxLease)
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to claim outbox events")
+			return
+		}
+
+		for _, event := range events {
+			msg := outbox.NewMessage(event)
+
+			err := publishToSinks(ctx, sinks, msg)
+			if err != nil && ctx.Err() != nil {
+				// shutting down, the lease brings the event back later
+				return
+			}
+
+			if err == nil {
+				err = app.Models.Outbox.MarkPublished(event.ID)
+				if err != nil {
+					app.Logger.Error().Err(err).Int64("event_id", event.ID).Msg("failed to mark outbox event published")
+				}
+				continue
+			}
+
+			event.Attempts++
+			event.LastError = err.Error()
+			event.NextAttemptAt = time.Now().Add(backoff(event.Attempts, outboxInitialBackoff, maxBackoff))
+
+			app.Logger.Warn().Err(err).
+				Int64("event_id", event.ID).
+				Str("event", event.Event).
+				Int("attempts", event.Attempts).
+				Msg("failed to publish outbox event")
+
+			err = app.Models.Outbox.RecordFailure(event)
+			if err != nil {
+				app.Logger.Error().Err(err).Int64("event_id", event.ID).Msg("failed to record outbox failure")
+			}
+		}
+
+		if len(events) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func publishToSinks(ctx context.Context, sinks []outbox.Sink, msg outbox.Message) error {
+	for _, sink := range sinks {
+		err := sink.Publish(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("%s: %v", sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+func cleanupOutbox(app *config.Application) {
+	retention := app.Config.Outbox.Retention
+	if retention <= 0 {
+		retention = DefaultOutboxRetention
+	}
+
+	deleted, err := app.Models.Outbox.DeletePublished(time.Now().Add(-retention))
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("failed to delete published outbox events")
+		return
+	}
+
+	if deleted > 0 {
+		app.Logger.Info().Int64("deleted", deleted).Msg("deleted published outbox events")
+	}
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
-	"os"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/khofesh/img-upload-view/internal/app/api/handlers"
@@ -11,29 +14,149 @@ import (
 	middlewares "github.com/khofesh/img-upload-view/internal/middleware"
 )
 
-func routes(app *config.Application) http.Handler {
+// routes returns the handler of the API and the registered routes as
+// "METHOD /path", to be checked against openapi.json
+func routes(app *config.Application) (http.Handler, []string) {
 	router := httprouter.New()
 
+	registered := []string{}
+	handle := func(method, path string, handler http.Handler) {
+		registered = append(registered, method+" "+path)
+		router.Handler(method, path, handler)
+	}
+
 	mw := middlewares.New(
 		middlewares.WithTrustedOrigins[data.Models](app.Config.TrustedOrigins),
 		middlewares.WithErrorResponse[data.Models](app.ErrorResponse),
+		middlewares.WithAPIKeys[data.Models](app.Config.Auth.APIKeys),
+		middlewares.WithAdminAPIKeys[data.Models](app.Config.Auth.AdminAPIKeys),
+		middlewares.WithSigner[data.Models](app.Signer),
 	)
 
-	router.HandlerFunc(http.MethodPost, "/upload", handlers.UploadImage(app))
-	router.HandlerFunc(http.MethodGet, "/images", handlers.GetImages(app))
-	router.HandlerFunc(http.MethodGet, "/image/:id", handlers.GetImageByID(app))
-	router.HandlerFunc(http.MethodDelete, "/image/:id", handlers.DeleteImage(app))
+	// reads only need credentials when auth.privateReads is set
+	protectRead := func(next http.Handler) http.Handler {
+		if app.Config.Auth.PrivateReads {
+			return mw.RequireAuthentication(next)
+		}
+		return next
+	}
+
+	// uploads are waited for on shutdown. Those still receiving their body
+	// when the shutdown timeout is near fail and remove what they stored.
+	trackUpload := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			done, ok := app.Background.Add()
+			if !ok {
+				w.Header().Set("Connection", "close")
+				app.ErrorResponse.ErrorResponse(w, r, http.StatusServiceUnavailable, "the server is shutting down")
+				return
+			}
+			defer done()
+
+			r.Body = app.Background.WrapBody(r.Body)
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	handle(http.MethodPost, "/upload", mw.RequireAuthentication(trackUpload(handlers.UploadImage(app))))
+	handle(http.MethodPost, "/upload/url", mw.RequireAuthentication(trackUpload(handlers.UploadImageFromURL(app))))
+	handle(http.MethodGet, "/images", protectRead(handlers.GetImages(app)))
+	handle(http.MethodGet, "/images/search", protectRead(handlers.SearchImages(app)))
+	handle(http.MethodPost, "/images/batch", mw.RequireAuthentication(handlers.BatchImages(app)))
+	handle(http.MethodPost, "/images/archive", protectRead(handlers.ArchiveImages(app)))
+	handle(http.MethodGet, "/image/:id", protectRead(handlers.GetImageByID(app)))
+	handle(http.MethodGet, "/image/:id/file", protectRead(handlers.GetImageFile(app)))
+	handle(http.MethodHead, "/image/:id/file", protectRead(handlers.GetImageFile(app)))
+	handle(http.MethodGet, "/image/:id/thumbnail", protectRead(handlers.GetImageThumbnail(app)))
+	handle(http.MethodPatch, "/image/:id", mw.RequireAuthentication(handlers.UpdateImage(app)))
+	handle(http.MethodDelete, "/image/:id", mw.RequireAuthentication(handlers.DeleteImage(app)))
+	handle(http.MethodPost, "/image/:id/restore", mw.RequireAuthentication(handlers.RestoreImage(app)))
+	handle(http.MethodGet, "/events", protectRead(handlers.StreamEvents(app)))
+	handle(http.MethodGet, "/trash", protectRead(handlers.GetTrash(app)))
+	handle(http.MethodGet, "/tags", protectRead(handlers.GetTags(app)))
+	handle(http.MethodPost, "/image/:id/tags", mw.RequireAuthentication(handlers.AddImageTags(app)))
+	handle(http.MethodDelete, "/image/:id/tags/:tag", mw.RequireAuthentication(handlers.RemoveImageTag(app)))
+	handle(http.MethodGet, "/albums", protectRead(handlers.GetAlbums(app)))
+	handle(http.MethodPost, "/albums", mw.RequireAuthentication(handlers.CreateAlbum(app)))
+	handle(http.MethodGet, "/albums/:id", protectRead(handlers.GetAlbum(app)))
+	handle(http.MethodPatch, "/albums/:id", mw.RequireAuthentication(handlers.UpdateAlbum(app)))
+	handle(http.MethodDelete, "/albums/:id", mw.RequireAuthentication(handlers.DeleteAlbum(app)))
+	handle(http.MethodGet, "/albums/:id/images", protectRead(handlers.GetAlbumImages(app)))
+	handle(http.MethodPost, "/albums/:id/images", mw.RequireAuthentication(handlers.AddAlbumImages(app)))
+	handle(http.MethodPut, "/albums/:id/images/order", mw.RequireAuthentication(handlers.ReorderAlbumImages(app)))
+	handle(http.MethodDelete, "/albums/:id/images/:image_id", mw.RequireAuthentication(handlers.RemoveAlbumImage(app)))
+	handle(http.MethodPost, "/presign", mw.RequireKey(handlers.CreatePresignedURL(app)))
+
+	// webhook subscriptions, admin keys only
+	handle(http.MethodGet, "/webhooks", mw.RequireAdmin(handlers.GetWebhooks(app)))
+	handle(http.MethodPost, "/webhooks", mw.RequireAdmin(handlers.CreateWebhook(app)))
+	handle(http.MethodGet, "/webhooks/:id", mw.RequireAdmin(handlers.GetWebhook(app)))
+	handle(http.MethodPatch, "/webhooks/:id", mw.RequireAdmin(handlers.UpdateWebhook(app)))
+	handle(http.MethodDelete, "/webhooks/:id", mw.RequireAdmin(handlers.DeleteWebhook(app)))
+	handle(http.MethodGet, "/webhooks/:id/deliveries", mw.RequireAdmin(handlers.GetWebhookDeliveries(app)))
+	handle(http.MethodPost, "/webhooks/:id/deliveries/:delivery_id/redeliver", mw.RequireAdmin(handlers.RedeliverWebhookDelivery(app)))
+
+	// background jobs, admin keys only
+	handle(http.MethodGet, "/jobs", mw.RequireAdmin(handlers.GetJobs(app)))
+	handle(http.MethodGet, "/jobs/:id", mw.RequireAdmin(handlers.GetJob(app)))
+	handle(http.MethodPost, "/jobs/:id/retry", mw.RequireAdmin(handlers.RetryJob(app)))
+
+	// resumable uploads (tus protocol)
+	handle(http.MethodOptions, handlers.TusBasePath, handlers.TusOptions(app))
+	handle(http.MethodPost, handlers.TusBasePath, mw.RequireAuthentication(handlers.TusCreate(app)))
+	handle(http.MethodHead, handlers.TusBasePath+":id", mw.RequireAuthentication(handlers.TusHead(app)))
+	handle(http.MethodPatch, handlers.TusBasePath+":id", mw.RequireAuthentication(trackUpload(handlers.TusPatch(app))))
+	handle(http.MethodDelete, handlers.TusBasePath+":id", mw.RequireAuthentication(handlers.TusDelete(app)))
+
+	handle(http.MethodGet, "/openapi.json", handlers.GetOpenAPISpec(app))
+	if app.Config.OpenAPI.DocsPage {
+		handle(http.MethodGet, "/docs", handlers.GetAPIDocs(app))
+	}
 
-	// serving files for development
+	// serving files for development, Image.URL points below /images/ which
+	// nginx serves otherwise. It shares the prefix with /images/search and
+	// friends, so it is the fallback for paths no route matched.
 	if app.Config.Env == "local" {
-		uploadDir := os.Getenv("UPLOAD_DIR")
-		if uploadDir == "" {
-			uploadDir = "./upload" // Default for local development
+		serveImage := protectRead(handlers.ServeLocalImage(app))
+		router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.HasPrefix(r.URL.Path, "/images/") {
+				serveImage.ServeHTTP(w, r)
+				return
+			}
+			http.NotFound(w, r)
+		})
+	}
+
+	return mw.RecoverPanic(mw.EnableCORS(router)), registered
+}
+
+// checkOpenAPISpec reports routes missing from openapi.json and operations in
+// it that are not routed
+func checkOpenAPISpec(registered []string) error {
+	documented, err := handlers.OpenAPIOperations()
+	if err != nil {
+		return fmt.Errorf("invalid openapi.json: %v", err)
+	}
+
+	problems := []string{}
+
+	for _, route := range registered {
+		if !slices.Contains(documented, route) {
+			problems = append(problems, route+" is not documented")
+		}
+	}
+
+	// /docs is optional
+	for _, operation := range documented {
+		if !slices.Contains(registered, operation) && operation != "GET /docs" {
+			problems = append(problems, operation+" has no route")
 		}
+	}
 
-		os.MkdirAll(uploadDir, 0755)
-		router.ServeFiles("/images/*filepath", http.Dir(uploadDir))
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi.json is out of date: %s", strings.Join(problems, ", "))
 	}
 
-	return mw.RecoverPanic(mw.EnableCORS(router))
+	return nil
 }
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/presign"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	for _, docsPage := range []bool{false, true} {
+		cfg := &config.Config{}
+		cfg.OpenAPI.DocsPage = docsPage
+
+		_, registered := routes(&config.Application{Config: cfg})
+
+		err := checkOpenAPISpec(registered)
+		if err != nil {
+			t.Errorf("docsPage %v: %v", docsPage, err)
+		}
+	}
+}
+
+func TestPresignNeedsKeyOnOpenAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	signer, err := presign.NewSigner("k1", map[string]string{"k1": "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.app.Signer = signer
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"not-a-key", http.StatusUnauthorized},
+		{testAdminKey, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/presign", strings.NewReader(`{"method": "POST", "path": "/upload"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.status {
+			t.Errorf("token %q: got status %d, want %d", tt.token, res.StatusCode, tt.status)
+		}
+	}
+}
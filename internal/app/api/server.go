@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,21 +25,57 @@ func (w *zerologWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+const (
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// the part of the shutdown timeout kept for cancelled work to clean up
+	shutdownCleanup = 5 * time.Second
+)
+
 func Serve(app *config.Application) error {
 
+	handler, registered := routes(app)
+
+	// a route without documentation is only reported here, the tests fail
+	// on it
+	err := checkOpenAPISpec(registered)
+	if err != nil {
+		app.Logger.Warn().Err(err).Msg("openapi.json does not match the routes")
+	}
+
+	sinks, err := outboxSinks(app)
+	if err != nil {
+		return err
+	}
+
 	zw := &zerologWriter{logger: app.Logger}
 	httpLogger := log.New(zw, "", 0)
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.Config.Port),
-		Handler:      routes(app),
+		Handler:      handler,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		ErrorLog:     httpLogger,
+		BaseContext: func(net.Listener) context.Context {
+			return app.Background.Context()
+		},
 	}
 
+	// event streams never finish on their own, they end when the shutdown
+	// starts and clients reconnect to another instance
+	srv.RegisterOnShutdown(app.Events.Close)
+
 	shutdownError := make(chan error)
 
+	// workers run until the shutdown, requests in progress share their
+	// context through BaseContext
+	app.Background.Go(func(ctx context.Context) { runTrashPurger(ctx, app) })
+	app.Background.Go(func(ctx context.Context) { runOutboxDispatcher(ctx, app, sinks) })
+	app.Background.Go(func(ctx context.Context) { runWebhookDispatcher(ctx, app) })
+	app.Background.Go(func(ctx context.Context) { runJobWorkers(ctx, app, jobHandlers(app)) })
+	app.Background.Go(func(ctx context.Context) { runEventListener(ctx, app, app.Events) })
+
 	go func() {
 		// intercept the signals
 		quit := make(chan os.Signal, 1)
@@ -47,22 +84,39 @@ func Serve(app *config.Application) error {
 
 		app.Logger.Info().Msg(fmt.Sprintf("shutting down server signal %s", s.String()))
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		timeout := app.Config.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+		// what still runs once requests had their time is cancelled and
+		// gets the rest to clean up
+		cleanup := min(shutdownCleanup, timeout/2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
+		drainCtx, cancelDrain := context.WithTimeout(ctx, timeout-cleanup)
+		defer cancelDrain()
+
+		err := srv.Shutdown(drainCtx)
 		if err != nil {
-			shutdownError <- err
+			app.Logger.Warn().Err(err).Msg("requests still in progress, cancelling them")
 		}
 
 		app.Logger.Info().Msg(fmt.Sprintf("completing background tasks addr %s", srv.Addr))
 
+		err = app.Background.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- fmt.Errorf("background tasks did not complete: %v", err)
+			return
+		}
+
 		shutdownError <- nil
 	}()
 
 	app.Logger.Info().Msg(fmt.Sprintf("starting server addr %s env %s", srv.Addr, app.Config.Env))
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+const (
+	DefaultTrashRetention     = 30 * 24 * time.Hour
+	DefaultTrashPurgeInterval = time.Hour
+
+	// images deleted per query, so a large backlog doesn't hold one long
+	// transaction
+	trashPurgeBatchSize = 100
+)
+
+// runTrashPurger permanently deletes images that have been in the trash for
+// longer than trash.retention, every trash.purgeInterval until ctx is done.
+func runTrashPurger(ctx context.Context, app *config.Application) {
+	retention := app.Config.Trash.Retention
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+
+	interval := app.Config.Trash.PurgeInterval
+	if interval <= 0 {
+		interval = DefaultTrashPurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeTrash(ctx, app, time.Now().Add(-retention))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeTrash(ctx context.Context, app *config.Application, before time.Time) {
+	purged := 0
+
+	for ctx.Err() == nil {
+		// rows go first, a file left behind is harmless while a row without
+		// its file is a broken image
+		filenames, err := app.Models.Image.PurgeTrashed(before, trashPurgeBatchSize)
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to purge trash")
+			return
+		}
+
+		for _, filename := range filenames {
+			// the sidecar goes first, a rebuild must not bring back an image
+			// whose file is gone
+			err := imagestore.DeleteSidecar(ctx, app.Storage, filename)
+			if err != nil {
+				app.Logger.Warn().Msgf("failed to delete sidecar of %s: %v", filename, err)
+			}
+
+			err = imagestore.DeleteThumbnail(ctx, app.Storage, filename)
+			if err != nil {
+				app.Logger.Warn().Msgf("failed to delete thumbnail of %s: %v", filename, err)
+			}
+
+			err = app.Storage.Delete(ctx, filename)
+			if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+				app.Logger.Warn().Msgf("failed to delete physical file %s: %v", filename, err)
+			}
+		}
+
+		purged += len(filenames)
+		if len(filenames) < trashPurgeBatchSize {
+			break
+		}
+	}
+
+	if purged > 0 {
+		app.Logger.Info().Int("purged", purged).Msg("purged trashed images")
+	}
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/app/api/handlers"
+	"github.com/khofesh/img-upload-view/internal/data"
+)
+
+// fakeUploads keeps tus uploads in memory, completing one inserts its image
+// into images
+type fakeUploads struct {
+	images *fakeImages
+
+	mu      sync.Mutex
+	uploads map[string]*data.Upload
+}
+
+func (m *fakeUploads) Insert(upload *data.Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *upload
+	m.uploads[upload.ID] = &stored
+	return nil
+}
+
+func (m *fakeUploads) Get(id string) (*data.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	copied := *upload
+	return &copied, nil
+}
+
+func (m *fakeUploads) UpdateOffset(id string, expectedOffset, newOffset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.Offset != expectedOffset || upload.ImageID != nil {
+		return data.ErrEditConflict
+	}
+	upload.Offset = newOffset
+	return nil
+}
+
+func (m *fakeUploads) Complete(id string, image *data.Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[id]
+	if !ok || upload.ImageID != nil {
+		return data.ErrEditConflict
+	}
+
+	err := m.images.Insert(image)
+	if err != nil {
+		return err
+	}
+	upload.ImageID = &image.ID
+	return nil
+}
+
+func (m *fakeUploads) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+	delete(m.uploads, id)
+	return nil
+}
+
+// tusRequest sends a tus request, the Tus-Resumable header included unless
+// headers sets it
+func (ts *testServer) tusRequest(t *testing.T, method, path string, headers map[string]string, body []byte) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res
+}
+
+// createTusUpload creates an upload for a JPEG of length bytes and returns
+// its path
+func (ts *testServer) createTusUpload(t *testing.T, length int) string {
+	t.Helper()
+
+	res := ts.tusRequest(t, http.MethodPost, handlers.TusBasePath, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("photo.jpg")) + ",filetype " + base64.StdEncoding.EncodeToString([]byte("image/jpeg")),
+	}, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create: got status %d", res.StatusCode)
+	}
+
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, handlers.TusBasePath) {
+		t.Fatalf("create: got location %q", location)
+	}
+	return location
+}
+
+func (ts *testServer) patchChunk(t *testing.T, location string, offset int, chunk []byte) *http.Response {
+	t.Helper()
+
+	return ts.tusRequest(t, http.MethodPatch, location, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}, chunk)
+}
+
+func (ts *testServer) uploadOffset(t *testing.T, location string) (int, int) {
+	t.Helper()
+
+	res := ts.tusRequest(t, http.MethodHead, location, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, -1
+	}
+
+	offset, err := strconv.Atoi(res.Header.Get("Upload-Offset"))
+	if err != nil {
+		t.Fatalf("head: invalid Upload-Offset %q", res.Header.Get("Upload-Offset"))
+	}
+	return res.StatusCode, offset
+}
+
+func (ts *testServer) chunkKeys(t *testing.T) []string {
+	t.Helper()
+
+	keys, err := ts.app.Storage.List(context.Background(), "tus/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestTusUpload(t *testing.T) {
+	ts := newTestServer(t)
+	content := testJPEG(t)
+	half := len(content) / 2
+
+	res := ts.tusRequest(t, http.MethodOptions, handlers.TusBasePath, nil, nil)
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Tus-Extension") != "creation,termination" {
+		t.Fatalf("options: got status %d, extensions %q", res.StatusCode, res.Header.Get("Tus-Extension"))
+	}
+
+	location := ts.createTusUpload(t, len(content))
+
+	res = ts.patchChunk(t, location, 0, content[:half])
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: got status %d, offset %q", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+
+	// the client resumes from the offset HEAD reports
+	if status, offset := ts.uploadOffset(t, location); offset != half {
+		t.Fatalf("head: got status %d, offset %d, want %d", status, offset, half)
+	}
+
+	res = ts.patchChunk(t, location, 0, content[half:])
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("stale offset: got status %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+
+	res = ts.patchChunk(t, location, half, content[half:])
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("last chunk: got status %d, offset %q", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+
+	imageID, err := strconv.ParseInt(res.Header.Get("Upload-Image-Id"), 10, 64)
+	if err != nil {
+		t.Fatalf("last chunk: invalid Upload-Image-Id %q", res.Header.Get("Upload-Image-Id"))
+	}
+
+	image, err := ts.app.Models.Image.GetByID(imageID)
+	if err != nil {
+		t.Fatalf("image of the upload: %v", err)
+	}
+	if image.OriginalFilename != "photo.jpg" || image.FileSize != int64(len(content)) || image.ContentType != "image/jpeg" {
+		t.Errorf("got image %+v", image)
+	}
+
+	obj, err := ts.app.Storage.Open(context.Background(), image.Filename)
+	if err != nil {
+		t.Fatalf("stored file: %v", err)
+	}
+	stored, _ := io.ReadAll(obj)
+	obj.Close()
+	if !bytes.Equal(stored, content) {
+		t.Errorf("stored file has %d bytes, not the %d uploaded", len(stored), len(content))
+	}
+
+	if keys := ts.chunkKeys(t); len(keys) != 0 {
+		t.Errorf("chunks left after completion: %v", keys)
+	}
+
+	res = ts.patchChunk(t, location, len(content), []byte{0})
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("patch after completion: got status %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestTusUploadRejections(t *testing.T) {
+	ts := newTestServer(t)
+	jpegType := "filetype " + base64.StdEncoding.EncodeToString([]byte("image/jpeg"))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"other protocol version", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "10", "Upload-Metadata": jpegType}, http.StatusPreconditionFailed},
+		{"no length", map[string]string{"Upload-Metadata": jpegType}, http.StatusBadRequest},
+		{"negative length", map[string]string{"Upload-Length": "-1", "Upload-Metadata": jpegType}, http.StatusBadRequest},
+		{"deferred length", map[string]string{"Upload-Defer-Length": "1", "Upload-Metadata": jpegType}, http.StatusBadRequest},
+		{"too large", map[string]string{"Upload-Length": strconv.Itoa(handlers.MaxUploadSize + 1), "Upload-Metadata": jpegType}, http.StatusRequestEntityTooLarge},
+		{"not a JPEG", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filetype " + base64.StdEncoding.EncodeToString([]byte("image/gif"))}, http.StatusBadRequest},
+		{"invalid metadata", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filetype %%%"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		res := ts.tusRequest(t, http.MethodPost, handlers.TusBasePath, tt.headers, nil)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.status)
+		}
+	}
+
+	location := ts.createTusUpload(t, 10)
+
+	res := ts.tusRequest(t, http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, []byte("0123"))
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("chunk without content type: got status %d, want %d", res.StatusCode, http.StatusUnsupportedMediaType)
+	}
+
+	res = ts.patchChunk(t, location, 0, []byte("0123456789a"))
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk past Upload-Length: got status %d, want %d", res.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if _, offset := ts.uploadOffset(t, location); offset != 0 {
+		t.Errorf("chunk past Upload-Length: offset moved to %d", offset)
+	}
+	if keys := ts.chunkKeys(t); len(keys) != 0 {
+		t.Errorf("chunk past Upload-Length: chunks kept %v", keys)
+	}
+
+	res = ts.patchChunk(t, handlers.TusBasePath+"unknown", 0, []byte("0123"))
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown upload: got status %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestTusUploadKeepsBytesBeforeDrop(t *testing.T) {
+	ts := newTestServer(t)
+	content := testJPEG(t)
+	sent := len(content) / 3
+
+	location := ts.createTusUpload(t, len(content))
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// announce the whole file, send a third of it and drop the connection
+	fmt.Fprintf(conn, "PATCH %s HTTP/1.1\r\nHost: test\r\nTus-Resumable: 1.0.0\r\nContent-Type: application/offset+octet-stream\r\nUpload-Offset: 0\r\nContent-Length: %d\r\n\r\n", location, len(content))
+	conn.Write(content[:sent])
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	offset := 0
+	for offset != sent && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		_, offset = ts.uploadOffset(t, location)
+	}
+	if offset != sent {
+		t.Fatalf("got offset %d after the drop, want %d", offset, sent)
+	}
+
+	res := ts.patchChunk(t, location, sent, content[sent:])
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Image-Id") == "" {
+		t.Fatalf("resumed chunk: got status %d, image %q", res.StatusCode, res.Header.Get("Upload-Image-Id"))
+	}
+}
+
+func TestTusUploadTermination(t *testing.T) {
+	ts := newTestServer(t)
+	content := testJPEG(t)
+
+	location := ts.createTusUpload(t, len(content))
+
+	res := ts.patchChunk(t, location, 0, content[:10])
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("chunk: got status %d", res.StatusCode)
+	}
+
+	res = ts.tusRequest(t, http.MethodDelete, location, nil, nil)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: got status %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+
+	if status, _ := ts.uploadOffset(t, location); status != http.StatusNotFound {
+		t.Errorf("head after delete: got status %d, want %d", status, http.StatusNotFound)
+	}
+	if keys := ts.chunkKeys(t); len(keys) != 0 {
+		t.Errorf("chunks left after delete: %v", keys)
+	}
+
+	res = ts.patchChunk(t, location, 10, content[10:])
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("patch after delete: got status %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+
+	res = ts.tusRequest(t, http.MethodDelete, location, nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete: got status %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
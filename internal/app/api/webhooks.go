@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/webhook"
+	"github.com/khofesh/img-upload-view/pkg/safefetch"
+)
+
+const (
+	DefaultWebhookPollInterval = 5 * time.Second
+	DefaultWebhookTimeout      = 10 * time.Second
+	DefaultWebhookMaxAttempts  = 8
+	DefaultWebhookRetryBackoff = 30 * time.Second
+
+	maxWebhookBackoff = 6 * time.Hour
+
+	// deliveries claimed and sent concurrently per query
+	webhookBatchSize = 20
+)
+
+// runWebhookDispatcher sends due webhook deliveries every
+// webhooks.pollInterval until ctx is done. A failed attempt is retried with
+// exponential backoff until webhooks.maxAttempts is reached.
+func runWebhookDispatcher(ctx context.Context, app *config.Application) {
+	interval := app.Config.Webhooks.PollInterval
+	if interval <= 0 {
+		interval = DefaultWebhookPollInterval
+	}
+
+	timeout := app.Config.Webhooks.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+
+	// the address is checked right before connecting as well, a hostname
+	// may resolve to a private address by the time a delivery is sent
+	client := safefetch.NewClient(safefetch.Options{
+		Timeout:              timeout,
+		AllowPrivateNetworks: app.Config.Webhooks.AllowPrivateNetworks,
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		dispatchWebhooks(ctx, app, client)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func dispatchWebhooks(ctx context.Context, app *config.Application, client *http.Client) {
+	// claimed deliveries are left alone by other instances until the lease
+	// runs out, which outlasts the attempt
+	lease := 2 * client.Timeout
+
+	for ctx.Err() == nil {
+		deliveries, err := app.Models.Webhook.ClaimDeliveries(webhookBatchSize, lease)
+		if err != nil {
+			app.Logger.Error().Err(err).Msg("failed to claim webhook deliveries")
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				attemptDelivery(ctx, app, client, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func attemptDelivery(ctx context.Context, app *config.Application, client *http.Client, delivery *data.WebhookDelivery) {
+	status, err := webhook.Send(ctx, client, webhook.Delivery{
+		ID:     delivery.ID,
+		URL:    delivery.URL,
+		Secret: delivery.Secret,
+		Event:  delivery.Event,
+		Body:   delivery.Payload,
+	})
+	if err != nil && ctx.Err() != nil {
+		// shutting down, the lease brings the delivery back later
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+
+	maxAttempts := app.Config.Webhooks.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+
+	initialBackoff := app.Config.Webhooks.RetryBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = DefaultWebhookRetryBackoff
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = data.DeliverySucceeded
+		delivery.LastError = ""
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = data.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = data.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts, initialBackoff, maxWebhookBackoff))
+	}
+
+	if err != nil {
+		app.Logger.Warn().Err(err).
+			Int64("delivery_id", delivery.ID).
+			Int64("webhook_id", delivery.WebhookID).
+			Int("attempts", delivery.Attempts).
+			Msg("webhook delivery failed")
+	}
+
+	err = app.Models.Webhook.RecordAttempt(delivery)
+	if err != nil {
+		app.Logger.Error().Err(err).Int64("delivery_id", delivery.ID).Msg("failed to record webhook delivery attempt")
+	}
+}
+
+// backoff returns the delay after the given failed attempt, starting at
+// initial and doubling up to maxBackoff
+func backoff(attempt int, initial, maxBackoff time.Duration) time.Duration {
+	d := initial
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return min(d, maxBackoff)
+}
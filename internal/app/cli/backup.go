@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// layout of a backup archive, a tar file with the stored files first, then
+// the rows and last the manifest with a checksum of everything before it
+const (
+	backupFormat       = "img-upload-view-backup"
+	backupVersion      = 1
+	backupFilesDir     = "files/"
+	backupRowsName     = "images.ndjson"
+	backupManifestName = "manifest.json"
+)
+
+type backupManifest struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Images    int           `json:"images"`
+	Entries   []backupEntry `json:"entries"`
+}
+
+type backupEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// runBackup - cli backup [flags]
+//
+// Writes every image row, trashed ones included, and every stored file into a
+// single archive that cli restore can load into another database and
+// storage.
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	output := flags.String("o", "", "archive to write (default backup-<timestamp>.tar)")
+	flags.Parse(args)
+
+	if *output == "" {
+		*output = fmt.Sprintf("backup-%s.tar", time.Now().UTC().Format("20060102-150405"))
+	}
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// written next to the output and renamed when complete, so a failed
+	// backup never looks like a good one
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	manifest, err := writeBackup(app, tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), *output); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up %d images to %s\n", manifest.Images, *output)
+	return nil
+}
+
+func writeBackup(app *config.Application, w io.Writer) (*backupManifest, error) {
+	images, err := app.Models.Image.GetAllWithTrashed()
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(w)
+	tw := tar.NewWriter(bw)
+
+	manifest := &backupManifest{
+		Format:    backupFormat,
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// addEntry writes one tar entry and records its checksum
+	addEntry := func(name string, size int64, modTime time.Time, content io.Reader) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    size,
+			ModTime: modTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		n, err := io.Copy(tw, io.TeeReader(content, hash))
+		if err != nil {
+			return err
+		}
+
+		manifest.Entries = append(manifest.Entries, backupEntry{Path: name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))})
+		return nil
+	}
+
+	ctx := context.Background()
+	rows := []byte{}
+
+	for _, image := range images {
+		obj, err := app.Storage.Open(ctx, image.Filename)
+		if err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				fmt.Fprintf(os.Stderr, "skipping image %d, %s is missing from storage\n", image.ID, image.Filename)
+				continue
+			}
+			return nil, err
+		}
+
+		info := obj.Info()
+		err = addEntry(backupFilesDir+image.Filename, info.Size, info.ModTime, obj)
+		obj.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to back up %s: %v", image.Filename, err)
+		}
+
+		row, err := json.Marshal(image)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(append(rows, row...), '\n')
+		manifest.Images++
+	}
+
+	err = addEntry(backupRowsName, int64(len(rows)), manifest.CreatedAt, bytes.NewReader(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0o644, Size: int64(len(js)), ModTime: manifest.CreatedAt})
+	if err == nil {
+		_, err = tw.Write(js)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
@@ -1,7 +1,107 @@
 package cli
 
-import "fmt"
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/db"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/pkg/errors"
+	readconfig "github.com/khofesh/img-upload-view/pkg/read-config"
+	"github.com/rs/zerolog"
+)
+
+const usage = `usage: cli <command> [flags] [args]
+
+commands:
+  import <dir|zip>    import JPEG files into storage and the database
+  backup              write the images table and all stored files into one archive
+  restore <archive>   verify a backup and load it into the database and storage
+  rebuild-index       recreate the images table from the JSON sidecars in storage
+  backfill-checksums  record the sha256 of images stored without one
+  jobs <list|retry>   inspect background jobs and retry dead ones
+
+run "cli <command> -h" for the flags of a command
+`
 
 func Cli() {
-	fmt.Println("cli application")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "rebuild-index":
+		err = runRebuildIndex(os.Args[2:])
+	case "backfill-checksums":
+		err = runBackfillChecksums(os.Args[2:])
+	case "jobs":
+		err = runJobs(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// configPathFlag registers the -config-path flag shared with the API
+func configPathFlag(fs *flag.FlagSet) *string {
+	return fs.String("config-path", "/etc/secrets/config.yaml", "path to config")
+}
+
+// newApplication wires the same dependencies as the API server. Model logs
+// are limited to warnings so they don't drown the command's own output.
+func newApplication(cfgPath string) (*config.Application, *sql.DB, error) {
+	var cfg config.Config
+	err := readconfig.ReadConfigFromFile(cfgPath, &cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger := zerolog.New(os.Stderr).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+
+	conn, err := db.OpenDB(cfg.Db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = storage.DefaultUploadDir
+	}
+
+	store, err := storage.NewLocalStorage(uploadDir)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	app := &config.Application{
+		Logger:        &logger,
+		Config:        &cfg,
+		Models:        data.NewModels(conn, &logger),
+		ErrorResponse: errors.NewErrorResponse(&logger),
+		Storage:       store,
+	}
+
+	return app, conn, nil
 }
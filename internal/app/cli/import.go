@@ -0,0 +1,672 @@
+package cli
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// importFile is one file found in the import source. name is the path
+// inside the source and the key in the checkpoint file.
+type importFile struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+type importStatus int
+
+const (
+	importStored importStatus = iota
+	importDuplicate
+	// an identical file is being imported, this one is a duplicate once
+	// that one is inserted
+	importWaiting
+	importFailed
+)
+
+type importResult struct {
+	file     importFile
+	status   importStatus
+	checksum string
+	image    *data.Image
+	err      error
+}
+
+type importSummary struct {
+	imported   int
+	duplicates int
+	resumed    int
+	failures   []importResult
+}
+
+// runImport - cli import [flags] <dir|zip>
+//
+// Files are validated like uploads, copied into storage by a pool of workers
+// and inserted in batches. Every batch that is committed, and every
+// duplicate, is appended to the checkpoint file, so an interrupted import
+// picks up where it stopped when run again. Failed files are retried.
+// Images keep the time of the import as their upload time.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	workers := flags.Int("workers", runtime.NumCPU(), "files copied into storage concurrently")
+	batchSize := flags.Int("batch-size", 100, "rows inserted per transaction")
+	checkpointPath := flags.String("checkpoint", "", "checkpoint file (default <source>.import-checkpoint)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cli import [flags] <dir|zip>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *workers < 1 || *batchSize < 1 {
+		return errors.New("-workers and -batch-size must be at least 1")
+	}
+
+	source := filepath.Clean(flags.Arg(0))
+	if *checkpointPath == "" {
+		*checkpointPath = source + ".import-checkpoint"
+	}
+
+	files, closeSource, err := listImportSource(source)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	checkpoint, err := openCheckpoint(*checkpointPath)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.close()
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = removeUninserted(app, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("importing %d files from %s with %d workers\n", len(files), source, *workers)
+	started := time.Now()
+
+	summary := importFiles(ctx, app, files, checkpoint, *workers, *batchSize)
+	summary.print(os.Stdout, time.Since(started))
+
+	if ctx.Err() != nil {
+		return errors.New("interrupted, run the same command again to resume")
+	}
+	return nil
+}
+
+func importFiles(ctx context.Context, app *config.Application, files []importFile, checkpoint *importCheckpoint, workers, batchSize int) *importSummary {
+	summary := &importSummary{}
+	jobs := make(chan importFile)
+	results := make(chan importResult)
+
+	// checksums claimed by the file being imported, so identical files in
+	// the source are only imported once
+	var seen sync.Map
+
+	go func() {
+		defer close(jobs)
+		for _, file := range files {
+			if checkpoint.done[file.name] {
+				summary.resumed++
+				continue
+			}
+			select {
+			case jobs <- file:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				results <- importOne(ctx, app, file, &seen, checkpoint)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	batch := []importResult{}
+	processed := 0
+
+	// checksums of the files inserted in this run or found in the database
+	known := map[string]bool{}
+	// files waiting for an identical file that is still being imported
+	waiting := map[string][]importResult{}
+
+	duplicate := func(result importResult) {
+		summary.duplicates++
+		if err := checkpoint.mark(result.file.name); err != nil {
+			app.Logger.Warn().Err(err).Msg("unable to update checkpoint")
+		}
+	}
+
+	// settled marks the files waiting on a checksum as duplicates, the
+	// identical file is in the database
+	settled := func(checksum string) {
+		known[checksum] = true
+		for _, result := range waiting[checksum] {
+			duplicate(result)
+		}
+		delete(waiting, checksum)
+	}
+
+	var handle func(result importResult)
+
+	// retry imports the first file waiting on a checksum after the identical
+	// file failed, the others wait for that one. Files still waiting when the
+	// import is interrupted are imported on resume.
+	retry := func(checksum string) {
+		queue := waiting[checksum]
+		if len(queue) == 0 || ctx.Err() != nil {
+			return
+		}
+
+		if len(queue) == 1 {
+			delete(waiting, checksum)
+		} else {
+			waiting[checksum] = queue[1:]
+		}
+		handle(importOne(ctx, app, queue[0].file, &seen, checkpoint))
+	}
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		// files retried below may start the next batch
+		pending := batch
+		batch = nil
+
+		images := make([]*data.Image, len(pending))
+		names := make([]string, len(pending))
+		for i, result := range pending {
+			images[i] = result.image
+			names[i] = result.file.name
+		}
+
+		err := app.Models.Image.InsertMany(images)
+		if err != nil {
+			removed := []string{}
+			for _, result := range pending {
+				if app.Storage.Delete(context.Background(), result.image.Filename) == nil {
+					removed = append(removed, result.image.Filename)
+				}
+				seen.Delete(result.checksum)
+				result.status, result.err = importFailed, fmt.Errorf("unable to save image metadata: %v", err)
+				summary.failures = append(summary.failures, result)
+			}
+
+			if err := checkpoint.settle(removed...); err != nil {
+				app.Logger.Warn().Err(err).Msg("unable to update checkpoint")
+			}
+
+			for _, result := range pending {
+				retry(result.checksum)
+			}
+		} else {
+			summary.imported += len(pending)
+
+			for _, image := range images {
+				err := imagestore.WriteSidecar(context.Background(), app.Storage, image)
+				if err != nil {
+					app.Logger.Warn().Err(err).Int64("image_id", image.ID).Msg("unable to write sidecar")
+				}
+			}
+
+			// the rows are in, a file that can't be marked is only imported
+			// again (as a duplicate) on resume
+			if err := checkpoint.mark(names...); err != nil {
+				app.Logger.Warn().Err(err).Msg("unable to update checkpoint")
+			}
+
+			filenames := make([]string, len(images))
+			for i, image := range images {
+				filenames[i] = image.Filename
+			}
+			if err := checkpoint.settle(filenames...); err != nil {
+				app.Logger.Warn().Err(err).Msg("unable to update checkpoint")
+			}
+
+			for _, result := range pending {
+				settled(result.checksum)
+			}
+		}
+	}
+
+	handle = func(result importResult) {
+		switch result.status {
+		case importStored:
+			batch = append(batch, result)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case importDuplicate:
+			duplicate(result)
+			settled(result.checksum)
+		case importWaiting:
+			if known[result.checksum] {
+				duplicate(result)
+				return
+			}
+
+			waiting[result.checksum] = append(waiting[result.checksum], result)
+
+			// the identical file failed before this one came in
+			if _, claimed := seen.Load(result.checksum); !claimed {
+				retry(result.checksum)
+			}
+		case importFailed:
+			summary.failures = append(summary.failures, result)
+			if result.checksum != "" {
+				retry(result.checksum)
+			}
+		}
+	}
+
+	for result := range results {
+		handle(result)
+
+		processed++
+		if processed%1000 == 0 {
+			fmt.Printf("%d files processed\n", processed)
+		}
+	}
+
+	// files retried by the last flush fill another batch
+	for len(batch) > 0 {
+		flush()
+	}
+
+	return summary
+}
+
+// importOne validates a file and copies it into storage. The row is inserted
+// later with its batch.
+func importOne(ctx context.Context, app *config.Application, file importFile, seen *sync.Map, checkpoint *importCheckpoint) importResult {
+	result := importResult{file: file, status: importFailed}
+
+	if file.size > imagestore.MaxUploadSize {
+		result.err = imagestore.ValidateFile(file.size, "")
+		return result
+	}
+
+	r, err := file.open()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(io.LimitReader(r, imagestore.MaxUploadSize+1))
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	// the content is sniffed, extensions in old archives can't be trusted
+	contentType := http.DetectContentType(content)
+	err = imagestore.ValidateFile(int64(len(content)), contentType)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	result.checksum = checksum
+
+	if _, loaded := seen.LoadOrStore(checksum, true); loaded {
+		result.status = importWaiting
+		return result
+	}
+
+	exists, err := app.Models.Image.ChecksumExists(checksum)
+	if err != nil {
+		seen.Delete(checksum)
+		result.err = err
+		return result
+	}
+	if exists {
+		result.status = importDuplicate
+		return result
+	}
+
+	// recorded before the file exists, so it is removed on the next run if
+	// the import is killed before its row is inserted
+	filename := imagestore.UniqueFilename(path.Base(file.name))
+	err = checkpoint.store(filename)
+	if err != nil {
+		seen.Delete(checksum)
+		result.err = fmt.Errorf("unable to update checkpoint: %v", err)
+		return result
+	}
+
+	image, err := imagestore.PutAs(ctx, app.Storage, filename, bytes.NewReader(content), path.Base(file.name), contentType)
+	if err != nil {
+		// storage only creates the file once it is complete
+		checkpoint.settle(filename)
+		seen.Delete(checksum)
+		result.err = err
+		return result
+	}
+
+	result.status = importStored
+	result.image = image
+	return result
+}
+
+// listImportSource lists the files of a directory tree or a zip archive.
+// Hidden files and directories are left out.
+func listImportSource(source string) ([]importFile, func(), error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.IsDir() {
+		return listZip(source)
+	}
+
+	files := []importFile{}
+	err = filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != source && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, importFile{
+			name: filepath.ToSlash(name),
+			size: info.Size(),
+			open: func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, func() {}, nil
+}
+
+func listZip(source string) ([]importFile, func(), error) {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is neither a directory nor a zip file: %v", source, err)
+	}
+
+	files := []importFile{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+
+		files = append(files, importFile{
+			name: f.Name,
+			size: int64(f.UncompressedSize64),
+			open: f.Open,
+		})
+	}
+
+	return files, func() { zr.Close() }, nil
+}
+
+// importCheckpoint is an append-only list of the files that need no further
+// work, one name per line. Next to it, <checkpoint>.stored lists the storage
+// keys written whose rows are not inserted yet, so a killed import doesn't
+// leave files behind that no image points at.
+type importCheckpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+
+	storedPath string
+	storedFile *os.File
+	stored     map[string]bool
+}
+
+func openCheckpoint(p string) (*importCheckpoint, error) {
+	c := &importCheckpoint{storedPath: p + ".stored"}
+
+	var err error
+	c.f, c.done, err = openCheckpointFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	c.storedFile, c.stored, err = openCheckpointFile(c.storedPath)
+	if err != nil {
+		c.f.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// openCheckpointFile opens p for appending and reads the lines in it
+func openCheckpointFile(p string) (*os.File, map[string]bool, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines := map[string]bool{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("unable to read checkpoint %s: %v", p, err)
+	}
+
+	return f, lines, nil
+}
+
+func (c *importCheckpoint) mark(names ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return appendLines(c.f, names...)
+}
+
+// store records a storage key before the file is written
+func (c *importCheckpoint) store(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stored[key] = true
+	return appendLines(c.storedFile, key)
+}
+
+// settle drops keys whose rows were inserted, or whose files were removed,
+// from <checkpoint>.stored. The file is replaced, so it only ever holds the
+// keys of the batch in progress.
+func (c *importCheckpoint) settle(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.stored, key)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.storedPath), filepath.Base(c.storedPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	pending := make([]string, 0, len(c.stored))
+	for key := range c.stored {
+		pending = append(pending, key)
+	}
+
+	err = appendLines(tmp, pending...)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), c.storedPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(c.storedPath, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	c.storedFile.Close()
+	c.storedFile = f
+
+	return nil
+}
+
+func (c *importCheckpoint) close() {
+	c.f.Close()
+	c.storedFile.Close()
+}
+
+func appendLines(f *os.File, lines ...string) error {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	_, err := f.WriteString(b.String())
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// removeUninserted deletes the files a killed import stored without
+// inserting their rows
+func removeUninserted(app *config.Application, checkpoint *importCheckpoint) error {
+	keys := []string{}
+	for key := range checkpoint.stored {
+		keys = append(keys, key)
+	}
+
+	removed := 0
+	for _, key := range keys {
+		exists, err := app.Models.Image.FilenameExists(key)
+		if err != nil {
+			return fmt.Errorf("unable to clean up the previous import: %v", err)
+		}
+		if exists {
+			continue
+		}
+
+		err = app.Storage.Delete(context.Background(), key)
+		if err == nil {
+			removed++
+		} else if !errors.Is(err, storage.ErrObjectNotFound) {
+			return fmt.Errorf("unable to clean up the previous import: %v", err)
+		}
+	}
+
+	if removed > 0 {
+		fmt.Printf("removed %d files the previous run stored but didn't insert\n", removed)
+	}
+
+	return checkpoint.settle(keys...)
+}
+
+// at most this many failures are listed in the summary
+const maxListedFailures = 100
+
+func (s *importSummary) print(w io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(w, "\nimported:             %d\n", s.imported)
+	fmt.Fprintf(w, "skipped (duplicate):  %d\n", s.duplicates)
+	fmt.Fprintf(w, "skipped (checkpoint): %d\n", s.resumed)
+	fmt.Fprintf(w, "failed:               %d\n", len(s.failures))
+	fmt.Fprintf(w, "elapsed:              %s\n", elapsed.Round(time.Second))
+
+	for i, failure := range s.failures {
+		if i == maxListedFailures {
+			fmt.Fprintf(w, "  ... and %d more\n", len(s.failures)-maxListedFailures)
+			break
+		}
+		fmt.Fprintf(w, "  %s: %v\n", failure.file.name, failure.err)
+	}
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/jobs"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+const jobsUsage = `usage: cli jobs <list|retry> [flags] [args]
+
+  list                list jobs, newest first
+  retry <id>          queue a dead job again
+  retry -all          queue every dead job again
+`
+
+// runJobs - cli jobs <list|retry> [flags] [args]
+//
+// Inspects the job queue and retries dead jobs, the same as the /jobs
+// endpoints.
+func runJobs(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, jobsUsage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		return runJobsList(args[1:])
+	case "retry":
+		return runJobsRetry(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown jobs command %q\n\n%s", args[0], jobsUsage)
+		os.Exit(2)
+	}
+
+	return nil
+}
+
+func runJobsList(args []string) error {
+	flags := flag.NewFlagSet("jobs list", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	status := flags.String("status", "", "only jobs in this state: queued, running, succeeded or dead")
+	kind := flags.String("kind", "", "only jobs of this kind, e.g. image.thumbnail")
+	limit := flags.Int64("limit", 50, "number of jobs to list")
+	flags.Parse(args)
+
+	filters := data.JobFilters{Status: *status, Kind: *kind}
+
+	v := validator.New()
+	data.ValidateJobFilters(v, filters)
+	if !v.Valid() {
+		return fmt.Errorf("-status %s", v.Errors["status"])
+	}
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	list, total, err := app.Models.Job.GetAll(filters, *limit, 0)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tATTEMPTS\tRUN AT\tLAST ERROR")
+	for _, job := range list {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d/%d\t%s\t%s\n",
+			job.ID, job.Kind, job.Status, job.Attempts, job.MaxAttempts,
+			job.RunAt.Format(time.RFC3339), job.LastError)
+	}
+	tw.Flush()
+
+	fmt.Printf("%d of %d jobs\n", len(list), total)
+	return nil
+}
+
+func runJobsRetry(args []string) error {
+	flags := flag.NewFlagSet("jobs retry", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	all := flags.Bool("all", false, "queue every dead job again")
+	kind := flags.String("kind", "", "with -all, only dead jobs of this kind")
+	flags.Parse(args)
+
+	var id int64
+	switch {
+	case *all && flags.NArg() == 0:
+	case !*all && flags.NArg() == 1:
+		var err error
+		id, err = strconv.ParseInt(flags.Arg(0), 10, 64)
+		if err != nil || id < 1 {
+			return fmt.Errorf("invalid job id %q", flags.Arg(0))
+		}
+	default:
+		fmt.Fprint(os.Stderr, jobsUsage)
+		os.Exit(2)
+	}
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if *all {
+		queued, err := app.Models.Job.RetryDead(*kind)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("queued: %d\n", queued)
+		return nil
+	}
+
+	job, err := app.Models.Job.Retry(id)
+	if err != nil {
+		return fmt.Errorf("unable to retry job %d: %v", id, err)
+	}
+
+	fmt.Printf("queued job %d (%s)\n", job.ID, job.Kind)
+	return nil
+}
+
+// enqueueThumbnail queues the thumbnail of a restored image, which unlike a
+// new one isn't queued by the image model; a worker of the API renders it
+func enqueueThumbnail(app *config.Application, image *data.Image) {
+	_, err := jobs.Enqueue(app.Models.Job, jobs.ImageThumbnail, jobs.ImagePayload{ImageID: image.ID})
+	if err != nil {
+		app.Logger.Warn().Err(err).Int64("image_id", image.ID).Msg("unable to enqueue thumbnail")
+	}
+}
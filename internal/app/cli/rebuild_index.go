@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// runRebuildIndex - cli rebuild-index [flags]
+//
+// Recreates the rows of the images table from the JSON sidecars in storage,
+// with their original ids. Sidecars whose image file is missing are left out.
+func runRebuildIndex(args []string) error {
+	flags := flag.NewFlagSet("rebuild-index", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	onConflict := flags.String("on-conflict", conflictSkip, "when an image exists already: fail, skip or overwrite")
+	dryRun := flags.Bool("dry-run", false, "only report what would be inserted")
+	flags.Parse(args)
+
+	if !validator.PermittedValue(*onConflict, conflictFail, conflictSkip, conflictOverwrite) {
+		return fmt.Errorf("-on-conflict must be %s, %s or %s", conflictFail, conflictSkip, conflictOverwrite)
+	}
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+
+	keys, err := imagestore.ListSidecars(ctx, app.Storage)
+	if err != nil {
+		return fmt.Errorf("unable to list sidecars: %v", err)
+	}
+
+	var inserted, replaced, skipped, invalid, missing int
+	described := map[string]bool{}
+
+	for _, key := range keys {
+		image, err := imagestore.ReadSidecar(ctx, app.Storage, key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			invalid++
+			continue
+		}
+		described[image.Filename] = true
+
+		_, err = app.Storage.Stat(ctx, image.Filename)
+		if err != nil {
+			if !errors.Is(err, storage.ErrObjectNotFound) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "skipping image %d, %s is missing from storage\n", image.ID, image.Filename)
+			missing++
+			continue
+		}
+
+		exists, err := app.Models.Image.Conflicts(image)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			switch *onConflict {
+			case conflictSkip:
+				skipped++
+				continue
+			case conflictFail:
+				return fmt.Errorf("image %d (%s) exists already after %d were inserted", image.ID, image.Filename, inserted)
+			}
+		}
+
+		if !*dryRun {
+			// a displaced file has a sidecar of its own and is handled
+			// with it, or reported as an orphan below
+			_, err = app.Models.Image.InsertRestored(image, exists)
+			if err != nil {
+				return fmt.Errorf("unable to insert image %d after %d were inserted: %v", image.ID, inserted, err)
+			}
+		}
+
+		inserted++
+		if exists {
+			replaced++
+		}
+	}
+
+	if !*dryRun && inserted > 0 {
+		err = app.Models.Image.ResetIDSequence()
+		if err != nil {
+			return err
+		}
+	}
+
+	// stored images are the files at the top of the storage, anything in a
+	// directory belongs to something else (sidecars, thumbnails, tus chunks)
+	files, err := app.Storage.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	orphans := 0
+	for _, key := range files {
+		if !strings.Contains(key, "/") && !described[key] {
+			orphans++
+		}
+	}
+
+	if *dryRun {
+		fmt.Print("dry run, ")
+	}
+	fmt.Printf("inserted: %d (%d overwritten), skipped existing: %d, invalid sidecars: %d, missing files: %d, files without sidecar: %d\n",
+		inserted, replaced, skipped, invalid, missing, orphans)
+	return nil
+}
@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/config"
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/imagestore"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// what happens to an image of the backup that has the same id or filename as
+// one in the database
+const (
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+// runRestore - cli restore [flags] <archive>
+//
+// The whole archive is verified against its manifest before anything is
+// written. Files go into storage first, then the rows are inserted with
+// their original ids. Albums are not part of a backup: restored images are
+// in no album, and an overwritten image leaves the albums it was in.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	cfgPath := configPathFlag(flags)
+	onConflict := flags.String("on-conflict", conflictFail, "when an image exists already: fail, skip or overwrite")
+	verifyOnly := flags.Bool("verify-only", false, "only check the archive against its manifest")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cli restore [flags] <archive>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if !validator.PermittedValue(*onConflict, conflictFail, conflictSkip, conflictOverwrite) {
+		return fmt.Errorf("-on-conflict must be %s, %s or %s", conflictFail, conflictSkip, conflictOverwrite)
+	}
+
+	archive := flags.Arg(0)
+
+	manifest, images, err := verifyBackup(archive)
+	if err != nil {
+		return fmt.Errorf("%s failed verification: %v", archive, err)
+	}
+
+	fmt.Printf("verified %s: %d images, %d entries, created %s\n",
+		archive, manifest.Images, len(manifest.Entries), manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+
+	if *verifyOnly {
+		return nil
+	}
+
+	app, conn, err := newApplication(*cfgPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// decide per image before touching anything, so "fail" leaves the
+	// database and storage as they were
+	skip := map[string]bool{}
+	replace := map[string]bool{}
+	conflicts := []string{}
+
+	for _, image := range images {
+		exists, err := app.Models.Image.Conflicts(image)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		switch *onConflict {
+		case conflictSkip:
+			skip[image.Filename] = true
+		case conflictOverwrite:
+			replace[image.Filename] = true
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%d (%s)", image.ID, image.Filename))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		shown := conflicts[:min(len(conflicts), 10)]
+		return fmt.Errorf("%d images already exist, e.g. %s; rerun with -on-conflict=skip or -on-conflict=overwrite",
+			len(conflicts), strings.Join(shown, ", "))
+	}
+
+	err = restoreFiles(archive, func(filename string, content io.Reader) error {
+		if skip[filename] {
+			return nil
+		}
+		_, err := app.Storage.Put(context.Background(), filename, content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to restore files: %v", err)
+	}
+
+	restored := 0
+	displaced := []string{}
+	for _, image := range images {
+		if skip[image.Filename] {
+			continue
+		}
+
+		filenames, err := app.Models.Image.InsertRestored(image, replace[image.Filename])
+		if err != nil {
+			return fmt.Errorf("unable to restore image %d after %d were restored: %v", image.ID, restored, err)
+		}
+		displaced = append(displaced, filenames...)
+
+		err = imagestore.WriteSidecar(context.Background(), app.Storage, image)
+		if err != nil {
+			app.Logger.Warn().Err(err).Int64("image_id", image.ID).Msg("unable to write sidecar")
+		}
+		enqueueThumbnail(app, image)
+		restored++
+	}
+
+	err = app.Models.Image.ResetIDSequence()
+	if err != nil {
+		return err
+	}
+
+	removeDisplaced(app, images, displaced)
+
+	fmt.Printf("restored: %d (%d overwritten), skipped: %d\n", restored, len(replace), len(skip))
+	return nil
+}
+
+// removeDisplaced deletes the files of the rows overwritten by id, which
+// had another filename than the image replacing them. A file that belongs to
+// another image of the backup was just restored and is kept.
+func removeDisplaced(app *config.Application, images []*data.Image, displaced []string) {
+	restored := map[string]bool{}
+	for _, image := range images {
+		restored[image.Filename] = true
+	}
+
+	ctx := context.Background()
+
+	for _, filename := range displaced {
+		if restored[filename] {
+			continue
+		}
+
+		err := imagestore.DeleteSidecar(ctx, app.Storage, filename)
+		if err != nil {
+			app.Logger.Warn().Msgf("failed to delete sidecar of %s: %v", filename, err)
+		}
+
+		err = imagestore.DeleteThumbnail(ctx, app.Storage, filename)
+		if err != nil {
+			app.Logger.Warn().Msgf("failed to delete thumbnail of %s: %v", filename, err)
+		}
+
+		err = app.Storage.Delete(ctx, filename)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			app.Logger.Warn().Msgf("failed to delete physical file %s: %v", filename, err)
+		}
+	}
+}
+
+// verifyBackup reads the whole archive, checks every entry against the
+// manifest and returns the image rows.
+func verifyBackup(archive string) (*backupManifest, []*data.Image, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var manifest *backupManifest
+	var images []*data.Image
+	found := map[string]backupEntry{}
+
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header.Name == backupManifestName {
+			manifest = &backupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		var rows bytes.Buffer
+
+		w := io.Writer(hash)
+		if header.Name == backupRowsName {
+			w = io.MultiWriter(hash, &rows)
+		}
+
+		size, err := io.Copy(w, tr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if header.Name == backupRowsName {
+			images, err = readBackupRows(&rows)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		found[header.Name] = backupEntry{Path: header.Name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.New("manifest.json is missing")
+	}
+	if manifest.Format != backupFormat || manifest.Version != backupVersion {
+		return nil, nil, fmt.Errorf("unsupported backup format %q version %d", manifest.Format, manifest.Version)
+	}
+
+	for _, entry := range manifest.Entries {
+		got, ok := found[entry.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is missing", entry.Path)
+		}
+		if got != entry {
+			return nil, nil, fmt.Errorf("%s does not match its checksum", entry.Path)
+		}
+		delete(found, entry.Path)
+	}
+
+	for name := range found {
+		return nil, nil, fmt.Errorf("%s is not listed in the manifest", name)
+	}
+
+	if len(images) != manifest.Images {
+		return nil, nil, fmt.Errorf("expected %d images, found %d", manifest.Images, len(images))
+	}
+
+	listed := map[string]bool{}
+	for _, entry := range manifest.Entries {
+		listed[entry.Path] = true
+	}
+	for _, image := range images {
+		if !listed[backupFilesDir+image.Filename] {
+			return nil, nil, fmt.Errorf("file of image %d is missing", image.ID)
+		}
+	}
+
+	return manifest, images, nil
+}
+
+func readBackupRows(r io.Reader) ([]*data.Image, error) {
+	images := []*data.Image{}
+
+	dec := json.NewDecoder(r)
+	for {
+		var image data.Image
+		err := dec.Decode(&image)
+		if errors.Is(err, io.EOF) {
+			return images, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", backupRowsName, err)
+		}
+		images = append(images, &image)
+	}
+}
+
+// restoreFiles calls put for every stored file in the archive
+func restoreFiles(archive string, put func(filename string, content io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		filename, ok := strings.CutPrefix(header.Name, backupFilesDir)
+		if !ok {
+			continue
+		}
+
+		err = put(filename, tr)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package background keeps track of work that must not be cut off when the
+// API shuts down: long-running workers and requests still in progress.
+package background
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrShuttingDown is returned for work started once Shutdown was called, and
+// by bodies wrapped with WrapBody once the remaining work is cancelled
+var ErrShuttingDown = errors.New("shutting down")
+
+// Manager is a WaitGroup whose tasks share a context that is cancelled on
+// shutdown. The zero value is not usable, see NewManager.
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+func NewManager() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel}
+}
+
+// Context is cancelled when Shutdown is called. Workers stop once it is
+// done, requests still running at that point clean up and return.
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Add registers work running in the caller's goroutine, e.g. a request.
+// done must be called when the work is over. ok is false, and nothing is
+// registered, once Shutdown was called.
+func (m *Manager) Add() (done func(), ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		return nil, false
+	}
+
+	m.wg.Add(1)
+	return sync.OnceFunc(m.wg.Done), true
+}
+
+// Go runs fn in its own goroutine with the context of the manager. fn must
+// return soon after ctx is done. It returns ErrShuttingDown, without running
+// fn, once Shutdown was called.
+func (m *Manager) Go(fn func(ctx context.Context)) error {
+	done, ok := m.Add()
+	if !ok {
+		return ErrShuttingDown
+	}
+
+	go func() {
+		defer done()
+		fn(m.ctx)
+	}()
+
+	return nil
+}
+
+// Shutdown refuses new work, cancels the context of the manager and waits
+// for the registered work to return, or for ctx to be done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closing = true
+	m.mu.Unlock()
+
+	m.cancel()
+
+	finished := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WrapBody makes reads from body fail with ErrShuttingDown once the
+// context of the manager is done. A request still receiving its body then
+// stops instead of holding up the shutdown, and drops what it stored.
+func (m *Manager) WrapBody(body io.ReadCloser) io.ReadCloser {
+	return &cancelableBody{ReadCloser: body, ctx: m.ctx}
+}
+
+type cancelableBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b *cancelableBody) Read(p []byte) (int, error) {
+	if b.ctx.Err() != nil {
+		return 0, ErrShuttingDown
+	}
+	return b.ReadCloser.Read(p)
+}
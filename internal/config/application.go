@@ -3,7 +3,11 @@ package config
 import (
 	errres "github.com/khofesh/img-upload-view/pkg/errors"
 
+	"github.com/khofesh/img-upload-view/internal/background"
 	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/events"
+	"github.com/khofesh/img-upload-view/internal/presign"
+	"github.com/khofesh/img-upload-view/internal/storage"
 	"github.com/rs/zerolog"
 )
 
@@ -12,4 +16,10 @@ type Application struct {
 	Config        *Config
 	Models        data.Models
 	ErrorResponse errres.ErrorResponse
+	Storage       storage.Storage
+	Signer        *presign.Signer
+	// Background tracks workers and uploads, Serve waits for them on shutdown
+	Background *background.Manager
+	// Events fans image events out to GET /events streams
+	Events *events.Hub
 }
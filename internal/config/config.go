@@ -3,13 +3,73 @@ package config
 import "time"
 
 type Config struct {
-	Port int    `yaml:"port"`
-	Env  string `yaml:"env"`
-	Db   struct {
+	Port            int           `yaml:"port"`
+	Env             string        `yaml:"env"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	Db              struct {
 		Dsn          string        `yaml:"dsn"`
 		MaxOpenConns int           `yaml:"maxOpenConns"`
 		MaxIdleConns int           `yaml:"maxIdleConns"`
 		MaxIdleTime  time.Duration `yaml:"maxIdleTime"`
 	} `yaml:"db"`
 	TrustedOrigins []string `yaml:"trustedOrigins"`
+	Upload         struct {
+		MaxBatchFiles    int `yaml:"maxBatchFiles"`
+		BatchConcurrency int `yaml:"batchConcurrency"`
+	} `yaml:"upload"`
+	Batch struct {
+		MaxImages int `yaml:"maxImages"`
+	} `yaml:"batch"`
+	RemoteImport struct {
+		Timeout              time.Duration `yaml:"timeout"`
+		MaxRedirects         int           `yaml:"maxRedirects"`
+		AllowPrivateNetworks bool          `yaml:"allowPrivateNetworks"`
+	} `yaml:"remoteImport"`
+	Auth struct {
+		APIKeys      []string `yaml:"apiKeys"`
+		AdminAPIKeys []string `yaml:"adminApiKeys"`
+		PrivateReads bool     `yaml:"privateReads"`
+	} `yaml:"auth"`
+	Trash struct {
+		Retention     time.Duration `yaml:"retention"`
+		PurgeInterval time.Duration `yaml:"purgeInterval"`
+	} `yaml:"trash"`
+	Signing struct {
+		ActiveKeyID string            `yaml:"activeKeyId"`
+		Keys        map[string]string `yaml:"keys"`
+		MaxExpiry   time.Duration     `yaml:"maxExpiry"`
+	} `yaml:"signing"`
+	Webhooks struct {
+		PollInterval time.Duration `yaml:"pollInterval"`
+		Timeout      time.Duration `yaml:"timeout"`
+		MaxAttempts  int           `yaml:"maxAttempts"`
+		RetryBackoff time.Duration `yaml:"retryBackoff"`
+		// AllowPrivateNetworks lets webhooks point at loopback and private
+		// addresses, for local development
+		AllowPrivateNetworks bool `yaml:"allowPrivateNetworks"`
+	} `yaml:"webhooks"`
+	Outbox struct {
+		PollInterval time.Duration `yaml:"pollInterval"`
+		MaxBackoff   time.Duration `yaml:"maxBackoff"`
+		Retention    time.Duration `yaml:"retention"`
+		Sinks        []string      `yaml:"sinks"`
+		NATS         struct {
+			URL           string        `yaml:"url"`
+			SubjectPrefix string        `yaml:"subjectPrefix"`
+			Timeout       time.Duration `yaml:"timeout"`
+		} `yaml:"nats"`
+	} `yaml:"outbox"`
+	Jobs struct {
+		Workers      int           `yaml:"workers"`
+		PollInterval time.Duration `yaml:"pollInterval"`
+		Timeout      time.Duration `yaml:"timeout"`
+		RetryBackoff time.Duration `yaml:"retryBackoff"`
+		Retention    time.Duration `yaml:"retention"`
+	} `yaml:"jobs"`
+	Events struct {
+		Heartbeat time.Duration `yaml:"heartbeat"`
+	} `yaml:"events"`
+	OpenAPI struct {
+		DocsPage bool `yaml:"docsPage"`
+	} `yaml:"openapi"`
 }
@@ -0,0 +1,476 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrOrderMismatch  = errors.New("image ids must list every image of the album exactly once")
+	ErrImageNotExists = errors.New("image does not exist")
+)
+
+type IAlbumModel interface {
+	Insert(album *Album) error
+	GetAll() ([]*Album, error)
+	Get(id int64) (*Album, error)
+	Update(album *Album) error
+	Delete(id int64) error
+	HasImage(albumID, imageID int64) (bool, error)
+	AddImages(albumID int64, imageIDs []int64) error
+	RemoveImage(albumID, imageID int64) error
+	Reorder(albumID int64, imageIDs []int64) error
+	GetImages(albumID, limit, offset int64) ([]*Image, int64, error)
+}
+
+type Album struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	CoverImageID *int64    `json:"cover_image_id"`
+	CoverFileURL string    `json:"cover_file_url,omitempty"`
+	ImageCount   int64     `json:"image_count"`
+	Version      int32     `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func ValidateAlbum(v *validator.Validator, album *Album) {
+	v.Check(album.Name != "", "name", "must be provided")
+	v.Check(validator.MaxChars(album.Name, 200), "name", "must not be more than 200 characters long")
+	v.Check(validator.MaxChars(album.Description, 5000), "description", "must not be more than 5000 characters long")
+}
+
+// ValidateAlbumImageIDs checks a list of image ids sent to add to or reorder
+// an album
+func ValidateAlbumImageIDs(v *validator.Validator, imageIDs []int64) {
+	v.Check(len(imageIDs) > 0, "image_ids", "must contain at least one image id")
+	v.Check(len(imageIDs) <= 1000, "image_ids", "must not contain more than 1000 image ids")
+
+	for _, id := range imageIDs {
+		v.Check(id > 0, "image_ids", "must only contain positive ids")
+	}
+
+	sorted := slices.Clone(imageIDs)
+	slices.Sort(sorted)
+	v.Check(len(slices.Compact(sorted)) == len(imageIDs), "image_ids", "must not contain duplicate ids")
+}
+
+const albumColumns = `a.id, a.name, a.description, a.cover_image_id, a.version, a.created_at, a.updated_at,
+	(SELECT COUNT(*) FROM album_images ai JOIN images i ON i.id = ai.image_id
+		WHERE ai.album_id = a.id AND i.deleted_at IS NULL) AS image_count`
+
+func scanAlbum(row rowScanner, album *Album) error {
+	var coverImageID sql.NullInt64
+
+	err := row.Scan(
+		&album.ID,
+		&album.Name,
+		&album.Description,
+		&coverImageID,
+		&album.Version,
+		&album.CreatedAt,
+		&album.UpdatedAt,
+		&album.ImageCount,
+	)
+	if err != nil {
+		return err
+	}
+
+	album.CoverImageID = nil
+	album.CoverFileURL = ""
+	if coverImageID.Valid {
+		album.CoverImageID = &coverImageID.Int64
+		album.CoverFileURL = imageFileURL(coverImageID.Int64)
+	}
+
+	return nil
+}
+
+type AlbumModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+func (m AlbumModel) Insert(album *Album) error {
+	query := `
+		INSERT INTO albums (name, description)
+		VALUES ($1, $2)
+		RETURNING id, version, created_at, updated_at`
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, album.Name, album.Description).Scan(
+		&album.ID,
+		&album.Version,
+		&album.CreatedAt,
+		&album.UpdatedAt,
+	)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to insert album")
+		return err
+	}
+
+	m.logger.Info().Int64("album_id", album.ID).Str("name", album.Name).Msg("Album inserted successfully")
+	return nil
+}
+
+func (m AlbumModel) GetAll() ([]*Album, error) {
+	query := `
+		SELECT ` + albumColumns + `
+		FROM albums a
+		ORDER BY a.name, a.id`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query albums")
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+	for rows.Next() {
+		var album Album
+		err := scanAlbum(rows, &album)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan album row")
+			return nil, err
+		}
+		albums = append(albums, &album)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+func (m AlbumModel) Get(id int64) (*Album, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + albumColumns + `
+		FROM albums a
+		WHERE a.id = $1`
+
+	var album Album
+	ctx := context.Background()
+
+	err := scanAlbum(m.postgresDB.QueryRowContext(ctx, query, id), &album)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Int64("album_id", id).Msg("Failed to get album by ID")
+		return nil, err
+	}
+
+	return &album, nil
+}
+
+// Update saves name, description and cover image, failing with
+// ErrEditConflict if the album changed since it was read
+func (m AlbumModel) Update(album *Album) error {
+	query := `
+		UPDATE albums
+		SET name = $1, description = $2, cover_image_id = $3, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND version = $5
+		RETURNING version, updated_at`
+
+	args := []any{
+		album.Name,
+		album.Description,
+		album.CoverImageID,
+		album.ID,
+		album.Version,
+	}
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, args...).Scan(&album.Version, &album.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		m.logger.Error().Err(err).Int64("album_id", album.ID).Msg("Failed to update album")
+		return err
+	}
+
+	album.CoverFileURL = ""
+	if album.CoverImageID != nil {
+		album.CoverFileURL = imageFileURL(*album.CoverImageID)
+	}
+
+	m.logger.Info().Int64("album_id", album.ID).Msg("Album updated successfully")
+	return nil
+}
+
+func (m AlbumModel) Delete(id int64) error {
+	query := `DELETE FROM albums WHERE id = $1`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", id).Msg("Failed to delete album")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.logger.Info().Int64("album_id", id).Msg("Album deleted successfully")
+	return nil
+}
+
+func (m AlbumModel) HasImage(albumID, imageID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM album_images WHERE album_id = $1 AND image_id = $2)`
+
+	var exists bool
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, albumID, imageID).Scan(&exists)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to check album image")
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// AddImages appends images to the end of the album in the given order.
+// Images already in the album keep their position. ErrImageNotExists is
+// returned if one of the ids is not an image or is in the trash.
+func (m AlbumModel) AddImages(albumID int64, imageIDs []int64) error {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the album so concurrent additions don't get the same positions
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM albums WHERE id = $1 FOR UPDATE`, albumID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to lock album")
+		return err
+	}
+
+	// the images are locked so they can't be trashed before the commit
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM images
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		FOR SHARE`, pq.Array(imageIDs))
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to lock images")
+		return err
+	}
+	defer rows.Close()
+
+	found := map[int64]bool{}
+	for rows.Next() {
+		var imageID int64
+		err := rows.Scan(&imageID)
+		if err != nil {
+			return err
+		}
+		found[imageID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, imageID := range imageIDs {
+		if !found[imageID] {
+			return ErrImageNotExists
+		}
+	}
+
+	query := `
+		INSERT INTO album_images (album_id, image_id, position)
+		SELECT $1, ids.image_id,
+			(SELECT COALESCE(MAX(position), 0) FROM album_images WHERE album_id = $1) + ids.ord
+		FROM unnest($2::int[]) WITH ORDINALITY AS ids(image_id, ord)
+		ON CONFLICT (album_id, image_id) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, albumID, pq.Array(imageIDs))
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to add images to album")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info().Int64("album_id", albumID).Int("count", len(imageIDs)).Msg("Images added to album successfully")
+	return nil
+}
+
+// RemoveImage takes an image out of the album, clearing the cover if it was
+// the cover image
+func (m AlbumModel) RemoveImage(albumID, imageID int64) error {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM album_images WHERE album_id = $1 AND image_id = $2`, albumID, imageID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to remove image from album")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE albums
+		SET cover_image_id = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND cover_image_id = $2`, albumID, imageID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to clear album cover")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info().Int64("album_id", albumID).Int64("image_id", imageID).Msg("Image removed from album successfully")
+	return nil
+}
+
+// Reorder sets the position of every image in the album. imageIDs must
+// contain exactly the images of the album, otherwise ErrOrderMismatch is
+// returned.
+func (m AlbumModel) Reorder(albumID int64, imageIDs []int64) error {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the rows so images can't be added or removed while reordering
+	rows, err := tx.QueryContext(ctx, `SELECT image_id FROM album_images WHERE album_id = $1 FOR UPDATE`, albumID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to lock album images")
+		return err
+	}
+
+	current := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	requested := slices.Clone(imageIDs)
+	slices.Sort(current)
+	slices.Sort(requested)
+	if !slices.Equal(current, requested) {
+		return ErrOrderMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE album_images
+		SET position = ids.ord
+		FROM unnest($2::int[]) WITH ORDINALITY AS ids(image_id, ord)
+		WHERE album_images.album_id = $1 AND album_images.image_id = ids.image_id`, albumID, pq.Array(imageIDs))
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to reorder album images")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info().Int64("album_id", albumID).Msg("Album reordered successfully")
+	return nil
+}
+
+// GetImages returns a page of the album's images in album order
+func (m AlbumModel) GetImages(albumID, limit, offset int64) ([]*Image, int64, error) {
+	var totalCount int64
+	countQuery := `
+		SELECT COUNT(*) FROM album_images ai JOIN images i ON i.id = ai.image_id
+		WHERE ai.album_id = $1 AND i.deleted_at IS NULL`
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, countQuery, albumID).Scan(&totalCount)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to get album image count")
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		JOIN album_images ai ON ai.image_id = images.id
+		WHERE ai.album_id = $1 AND images.deleted_at IS NULL
+		ORDER BY ai.position, ai.image_id
+		LIMIT $2 OFFSET $3`
+
+	rows, err := m.postgresDB.QueryContext(ctx, query, albumID, limit, offset)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("album_id", albumID).Msg("Failed to query album images")
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		err := scanImage(rows, &image)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan image row")
+			return nil, 0, err
+		}
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, 0, err
+	}
+
+	return images, totalCount, nil
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// GetAllWithTrashed returns every image row, trashed ones included, ordered
+// by id. It is meant for backups and loads the whole table.
+func (m ImageModel) GetAllWithTrashed() ([]*Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		ORDER BY id`
+
+	return m.queryImages(query)
+}
+
+// Conflicts reports whether a row with the id or the stored filename of image
+// exists already
+func (m ImageModel) Conflicts(image *Image) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM images WHERE id = $1 OR filename = $2)`
+
+	var exists bool
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, image.ID, image.Filename).Scan(&exists)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to check image conflict")
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// InsertRestored inserts an image from a backup as it was, id, version,
+// timestamps and tags included. With replace, rows with the same id or
+// filename are deleted first, otherwise such a row makes the insert fail.
+// The stored files of the deleted rows, when they differ from the filename
+// of image, are returned for the caller to remove once nothing else uses
+// them. A replaced row with the same id gets an image.updated event and one
+// with only the same filename an image.deleted event; a new row gets none,
+// its image was created before the backup was taken.
+func (m ImageModel) InsertRestored(image *Image, replace bool) ([]string, error) {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the rows the image replaces, as they were
+	replaced := []*Image{}
+
+	if replace {
+		replaced, err = selectReplacedImages(ctx, tx, image)
+		if err != nil {
+			m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to replace image")
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM images WHERE id = $1 OR filename = $2`, image.ID, image.Filename)
+		if err != nil {
+			m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to replace image")
+			return nil, err
+		}
+	}
+
+	query := `
+		INSERT INTO images (id, filename, original_filename, url, file_size, content_type, checksum,
+			title, description, alt_text, taken_at, deleted_at, version, upload_timestamp, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11, $12, $13, $14, $15, $16)`
+
+	args := []any{
+		image.ID,
+		image.Filename,
+		image.OriginalFilename,
+		image.URL,
+		image.FileSize,
+		image.ContentType,
+		image.Checksum,
+		image.Title,
+		image.Description,
+		image.AltText,
+		image.TakenAt,
+		image.DeletedAt,
+		image.Version,
+		image.UploadTimestamp,
+		image.CreatedAt,
+		image.UpdatedAt,
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to insert restored image")
+		return nil, err
+	}
+
+	if len(image.Tags) > 0 {
+		_, err = tx.ExecContext(ctx, attachTagsQuery, pq.Array([]int64{image.ID}), pq.Array(image.Tags))
+		if err != nil {
+			m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to restore image tags")
+			return nil, err
+		}
+	}
+
+	// a row with the same id is updated as far as consumers are concerned,
+	// one that only shared the filename is gone
+	displaced := []string{}
+	deleted := []*Image{}
+	updated := false
+
+	for _, old := range replaced {
+		if old.Filename != image.Filename {
+			displaced = append(displaced, old.Filename)
+		}
+		if old.ID == image.ID {
+			updated = true
+		} else {
+			deleted = append(deleted, old)
+		}
+	}
+
+	err = writeImageEvents(ctx, tx, EventImageDeleted, true, deleted...)
+	if err == nil && updated {
+		err = writeImageEventsByID(ctx, tx, EventImageUpdated, image.ID)
+	}
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to write image event")
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return displaced, nil
+}
+
+// selectReplacedImages locks and loads the rows with the id or the filename
+// of image
+func selectReplacedImages(ctx context.Context, q querier, image *Image) ([]*Image, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id FROM images WHERE id = $1 OR filename = $2 FOR UPDATE`, image.ID, image.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return []*Image{}, nil
+	}
+
+	return selectImages(ctx, q, ids)
+}
+
+// ResetIDSequence moves the id sequence past the highest id, needed after
+// rows were inserted with explicit ids
+func (m ImageModel) ResetIDSequence() error {
+	query := `SELECT setval(pg_get_serial_sequence('images', 'id'), GREATEST(MAX(id), 1)) FROM images`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to reset image id sequence")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/lib/pq"
+)
+
+// operations of POST /images/batch
+const (
+	BatchDelete = "delete"
+	BatchUpdate = "update"
+	BatchTag    = "tag"
+)
+
+// statuses of a BatchResult
+const (
+	BatchStatusOK       = "ok"
+	BatchStatusNotFound = "not_found"
+)
+
+// ImageBatch is one operation applied to many images. ContentType and
+// OriginalFilename are only used by BatchUpdate, Tags only by BatchTag.
+type ImageBatch struct {
+	Operation        string
+	IDs              []int64
+	ContentType      *string
+	OriginalFilename *string
+	Tags             []string
+}
+
+type BatchResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func ValidateImageBatch(v *validator.Validator, batch *ImageBatch, maxImages int) {
+	v.Check(validator.PermittedValue(batch.Operation, BatchDelete, BatchUpdate, BatchTag), "operation", "must be delete, update or tag")
+
+	v.Check(len(batch.IDs) > 0, "ids", "must contain at least one image id")
+	v.Check(len(batch.IDs) <= maxImages, "ids", fmt.Sprintf("must not contain more than %d image ids", maxImages))
+	for _, id := range batch.IDs {
+		v.Check(id > 0, "ids", "must only contain positive ids")
+	}
+
+	sorted := slices.Clone(batch.IDs)
+	slices.Sort(sorted)
+	v.Check(len(slices.Compact(sorted)) == len(batch.IDs), "ids", "must not contain duplicate ids")
+
+	switch batch.Operation {
+	case BatchUpdate:
+		v.Check(batch.ContentType != nil || batch.OriginalFilename != nil, "operation", "update needs content_type or original_filename")
+		if batch.ContentType != nil {
+			v.Check(validator.PermittedValue(*batch.ContentType, "image/jpeg", "image/jpg"), "content_type", "must be image/jpeg or image/jpg")
+		}
+		if batch.OriginalFilename != nil {
+			name := *batch.OriginalFilename
+			v.Check(name != "", "original_filename", "must be provided")
+			v.Check(validator.MaxChars(name, 255), "original_filename", "must not be more than 255 characters long")
+			v.Check(!strings.ContainsAny(name, `/\`), "original_filename", "must not contain path separators")
+		}
+	case BatchTag:
+		ValidateTags(v, batch.Tags)
+	}
+}
+
+// ApplyBatch runs the operation on every image of the batch in one
+// transaction. Images that don't exist or are already in the trash are
+// reported as not found and skipped, a database error rolls everything back.
+// The results are in the order of batch.IDs.
+func (m ImageModel) ApplyBatch(batch *ImageBatch) ([]*BatchResult, error) {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the rows so they can't be trashed or edited halfway through
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM images
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE`, pq.Array(batch.IDs))
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to lock batch images")
+		return nil, err
+	}
+
+	found := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found = append(found, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(found) > 0 {
+		switch batch.Operation {
+		case BatchDelete:
+			_, err = tx.ExecContext(ctx, `UPDATE images SET deleted_at = CURRENT_TIMESTAMP WHERE id = ANY($1)`, pq.Array(found))
+		case BatchUpdate:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE images
+				SET content_type = COALESCE($2, content_type),
+					original_filename = COALESCE($3, original_filename),
+					version = version + 1,
+					updated_at = CURRENT_TIMESTAMP
+				WHERE id = ANY($1)`, pq.Array(found), batch.ContentType, batch.OriginalFilename)
+		case BatchTag:
+			_, err = tx.ExecContext(ctx, attachTagsQuery, pq.Array(found), pq.Array(batch.Tags))
+		}
+		if err != nil {
+			m.logger.Error().Err(err).Str("operation", batch.Operation).Msg("Failed to apply image batch")
+			return nil, err
+		}
+
+		event := EventImageUpdated
+		if batch.Operation == BatchDelete {
+			event = EventImageDeleted
+		}
+
+		err = writeImageEventsByID(ctx, tx, event, found...)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to write image events")
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to commit image batch")
+		return nil, err
+	}
+
+	results := make([]*BatchResult, len(batch.IDs))
+	for i, id := range batch.IDs {
+		results[i] = &BatchResult{ID: id, Status: BatchStatusNotFound}
+		if _, ok := slices.BinarySearch(found, id); ok {
+			results[i].Status = BatchStatusOK
+		}
+	}
+
+	m.logger.Info().
+		Str("operation", batch.Operation).
+		Int("requested", len(batch.IDs)).
+		Int("applied", len(found)).
+		Msg("Image batch applied successfully")
+
+	return results, nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CountMode selects how the total of a listing is worked out
+type CountMode string
+
+const (
+	CountExact    CountMode = "exact"
+	CountEstimate CountMode = "estimate"
+	CountNone     CountMode = "none"
+)
+
+// CursorSorts are the sorts keyset pagination supports
+var CursorSorts = []string{"upload_timestamp", "-upload_timestamp"}
+
+// ImageCursor points just past the last image of a page. Encoded, it is
+// opaque to clients, who hand it back to get the next page.
+type ImageCursor struct {
+	Sort            string    `json:"s"`
+	UploadTimestamp time.Time `json:"t"`
+	ID              int64     `json:"id"`
+}
+
+func (c ImageCursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeImageCursor parses a cursor returned by Encode. An empty string is
+// the start of the listing and decodes to nil.
+func DecodeImageCursor(s string) (*ImageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c ImageCursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 || c.UploadTimestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// GetAfter returns the page of images following cursor, nil for the first
+// page, using the (upload_timestamp, id) index instead of an OFFSET. Images
+// uploaded while a client pages through don't shift later pages. The
+// returned cursor is nil on the last page.
+func (m ImageModel) GetAfter(limit int64, cursor *ImageCursor, filters ImageFilters) ([]*Image, *ImageCursor, error) {
+	if filters.Sort == "" {
+		filters.Sort = DefaultImageSort
+	}
+
+	// one extra row tells whether there is a next page
+	where, args := filters.where([]any{limit + 1})
+
+	if cursor != nil {
+		comparison := ">"
+		if strings.HasPrefix(filters.Sort, "-") {
+			comparison = "<"
+		}
+
+		args = append(args, cursor.UploadTimestamp, cursor.ID)
+		condition := fmt.Sprintf("(upload_timestamp, id) %s ($%d, $%d)", comparison, len(args)-1, len(args))
+
+		where += " AND " + condition
+	}
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		` + where + `
+		` + filters.orderBy() + `
+		LIMIT $1`
+
+	images, err := m.queryImages(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var next *ImageCursor
+	if int64(len(images)) > limit {
+		images = images[:limit]
+		last := images[len(images)-1]
+		next = &ImageCursor{Sort: filters.Sort, UploadTimestamp: last.UploadTimestamp, ID: last.ID}
+	}
+
+	m.logger.Info().
+		Int("returned_count", len(images)).
+		Int64("limit", limit).
+		Bool("has_more", next != nil).
+		Msg("Images retrieved successfully")
+
+	return images, next, nil
+}
+
+// Count returns how many images match filters. CountEstimate reads the
+// planner's row estimate from pg_class, which is only available for the whole
+// table (trash included), so with filters or a table that was never analyzed
+// it counts exactly. estimated reports which one happened.
+func (m ImageModel) Count(filters ImageFilters, mode CountMode) (count int64, estimated bool, err error) {
+	ctx := context.Background()
+	where, args := filters.where([]any{})
+
+	if mode == CountEstimate && !filters.narrowed() {
+		query := `SELECT reltuples::bigint FROM pg_class WHERE oid = 'images'::regclass`
+
+		err = m.postgresDB.QueryRowContext(ctx, query).Scan(&count)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to estimate image count")
+			return 0, false, err
+		}
+
+		if count >= 0 {
+			return count, true, nil
+		}
+	}
+
+	err = m.postgresDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM images `+where, args...).Scan(&count)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to get total image count")
+		return 0, false, err
+	}
+
+	return count, false, nil
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImageCursor(t *testing.T) {
+	cursor := ImageCursor{Sort: "-upload_timestamp", UploadTimestamp: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC), ID: 42}
+
+	decoded, err := DecodeImageCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Sort != cursor.Sort || !decoded.UploadTimestamp.Equal(cursor.UploadTimestamp) || decoded.ID != cursor.ID {
+		t.Fatalf("got %+v, want %+v", decoded, cursor)
+	}
+
+	decoded, err = DecodeImageCursor("")
+	if err != nil || decoded != nil {
+		t.Fatalf("empty cursor: got %+v, error %v", decoded, err)
+	}
+
+	encode := func(js string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(js))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"upload_timestamp","t":"2024-05-01T12:30:00Z","id":1}`))},
+		{"not JSON", encode("42")},
+		{"truncated", cursor.Encode()[:10]},
+		{"no id", encode(`{"s":"upload_timestamp","t":"2024-05-01T12:30:00Z"}`)},
+		{"negative id", encode(`{"s":"upload_timestamp","t":"2024-05-01T12:30:00Z","id":-1}`)},
+		{"no timestamp", encode(`{"s":"upload_timestamp","id":1}`)},
+		{"invalid timestamp", encode(`{"s":"upload_timestamp","t":"yesterday","id":1}`)},
+		{"id of another type", encode(`{"s":"upload_timestamp","t":"2024-05-01T12:30:00Z","id":"1"}`)},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeImageCursor(tt.cursor)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, ErrInvalidCursor)
+		}
+	}
+}
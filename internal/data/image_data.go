@@ -4,27 +4,240 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog"
 )
 
 type IImageModel interface {
 	Insert(image *Image) error
-	GetAll(limit, offset int64) ([]*Image, int64, error)
+	InsertMany(images []*Image) error
+	GetAll(limit, offset int64, filters ImageFilters) ([]*Image, int64, error)
+	GetAfter(limit int64, cursor *ImageCursor, filters ImageFilters) ([]*Image, *ImageCursor, error)
+	Count(filters ImageFilters, mode CountMode) (int64, bool, error)
 	GetByID(id int64) (*Image, error)
 	Delete(id int64) error
+	GetByIDWithTrashed(id int64) (*Image, error)
+	GetByIDs(ids []int64) ([]*Image, error)
+	ChecksumExists(checksum string) (bool, error)
+	FilenameExists(filename string) (bool, error)
+	GetAllWithTrashed() ([]*Image, error)
+	Conflicts(image *Image) (bool, error)
+	InsertRestored(image *Image, replace bool) ([]string, error)
+	ResetIDSequence() error
+	Trash(id int64) error
+	Restore(id int64) error
+	PurgeTrashed(before time.Time, limit int) ([]string, error)
+	ApplyBatch(batch *ImageBatch) ([]*BatchResult, error)
 	GetByFilename(filename string) (*Image, error)
+	SetChecksum(id int64, checksum string) error
+	Update(image *Image) error
+	Search(q string, limit, offset int64) ([]*SearchResult, int64, error)
 }
 
 type Image struct {
-	ID               int64     `json:"id"`
-	Filename         string    `json:"filename"`
-	OriginalFilename string    `json:"original_filename"`
-	URL              string    `json:"url"`
-	FileSize         int64     `json:"file_size"`
-	ContentType      string    `json:"content_type"`
-	UploadTimestamp  time.Time `json:"upload_timestamp"`
+	ID               int64      `json:"id"`
+	Filename         string     `json:"filename"`
+	OriginalFilename string     `json:"original_filename"`
+	URL              string     `json:"url"`
+	FileSize         int64      `json:"file_size"`
+	ContentType      string     `json:"content_type"`
+	Checksum         string     `json:"checksum,omitempty"`
+	FileURL          string     `json:"file_url"`
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	AltText          string     `json:"alt_text"`
+	Tags             []string   `json:"tags"`
+	TakenAt          *time.Time `json:"taken_at"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	Version          int32      `json:"version"`
+	UploadTimestamp  time.Time  `json:"upload_timestamp"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// ValidateImageMetadata checks the user editable fields of an image
+func ValidateImageMetadata(v *validator.Validator, image *Image) {
+	v.Check(validator.MaxChars(image.Title, 200), "title", "must not be more than 200 characters long")
+	v.Check(validator.MaxChars(image.Description, 5000), "description", "must not be more than 5000 characters long")
+	v.Check(validator.MaxChars(image.AltText, 500), "alt_text", "must not be more than 500 characters long")
+}
+
+// imageColumns is the select list read by scanImage
+const imageColumns = `id, filename, original_filename, url, file_size, content_type, COALESCE(checksum, ''),
+	title, description, alt_text, taken_at, deleted_at, version, upload_timestamp, created_at, updated_at,
+	ARRAY(SELECT t.name FROM image_tags it JOIN tags t ON t.id = it.tag_id WHERE it.image_id = images.id ORDER BY t.name) AS tags`
+
+// ImageFilters narrows down and orders GetAll. Tags are matched by name, an
+// image needs every tag when TagsMatchAll is set and at least one otherwise.
+// Zero values leave a filter out, trashed images are always left out unless
+// Trashed is set.
+type ImageFilters struct {
+	Tags           []string
+	TagsMatchAll   bool
+	ContentType    string
+	MinSize        int64
+	MaxSize        int64
+	UploadedAfter  time.Time
+	UploadedBefore time.Time
+	// Trashed lists the images in the trash instead of the live ones
+	Trashed bool
+	// Sort is one of ImageSortSafelist, a leading "-" sorts descending
+	Sort string
+}
+
+// DefaultImageSort lists the newest uploads first
+const DefaultImageSort = "-upload_timestamp"
+
+// imageSortColumns maps the sort values accepted from clients to columns,
+// only these ever end up in the ORDER BY clause
+var imageSortColumns = map[string]string{
+	"upload_timestamp":  "upload_timestamp",
+	"file_size":         "file_size",
+	"original_filename": "original_filename",
+	"taken_at":          "taken_at",
+}
+
+// ImageSortSafelist is every accepted value of ImageFilters.Sort
+var ImageSortSafelist = []string{
+	"upload_timestamp", "-upload_timestamp",
+	"file_size", "-file_size",
+	"original_filename", "-original_filename",
+	"taken_at", "-taken_at",
+}
+
+func ValidateImageFilters(v *validator.Validator, f ImageFilters) {
+	v.Check(len(f.Tags) <= MaxTagsPerRequest, "tag", "must not be given more than 20 times")
+	v.Check(validator.PermittedValue(f.Sort, ImageSortSafelist...), "sort", "invalid sort value")
+	v.Check(validator.MaxChars(f.ContentType, 100), "content_type", "must not be more than 100 characters long")
+	v.Check(f.MinSize >= 0, "min_size", "must not be negative")
+	v.Check(f.MaxSize >= 0, "max_size", "must not be negative")
+	v.Check(f.MaxSize == 0 || f.MinSize <= f.MaxSize, "max_size", "must not be less than min_size")
+	v.Check(f.UploadedAfter.IsZero() || f.UploadedBefore.IsZero() || f.UploadedAfter.Before(f.UploadedBefore),
+		"uploaded_before", "must be later than uploaded_after")
+}
+
+// orderBy builds the ORDER BY clause. id breaks ties so pages are stable, and
+// images without a taken_at come last in both directions.
+func (f ImageFilters) orderBy() string {
+	if f.Trashed && f.Sort == "" {
+		return "ORDER BY deleted_at DESC, id DESC"
+	}
+
+	sort := f.Sort
+	if sort == "" {
+		sort = DefaultImageSort
+	}
+
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+	}
+
+	column, ok := imageSortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		// ValidateImageFilters rejects anything else before a query is built
+		panic("unsafe image sort parameter: " + sort)
+	}
+
+	return fmt.Sprintf("ORDER BY %s %s NULLS LAST, id %s", column, direction, direction)
+}
+
+// where builds the WHERE clause for the filters, numbering placeholders
+// after the args that are already in use.
+func (f ImageFilters) where(args []any) (string, []any) {
+	conditions := []string{"deleted_at IS NULL"}
+	if f.Trashed {
+		conditions = []string{"deleted_at IS NOT NULL"}
+	}
+
+	// condition appends value to args and formats it into the condition
+	condition := func(format string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(args))))
+	}
+
+	if len(f.Tags) > 0 {
+		args = append(args, pq.Array(f.Tags))
+		tagsParam := fmt.Sprintf("$%d", len(args))
+
+		if f.TagsMatchAll {
+			// compared with the distinct tags asked for, a tag may be given twice
+			conditions = append(conditions, fmt.Sprintf(`(
+				SELECT COUNT(DISTINCT t.name) FROM image_tags it JOIN tags t ON t.id = it.tag_id
+				WHERE it.image_id = images.id AND t.name = ANY(%[1]s)) = (
+				SELECT COUNT(DISTINCT name) FROM unnest(%[1]s::text[]) AS name)`, tagsParam))
+		} else {
+			conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM image_tags it JOIN tags t ON t.id = it.tag_id
+				WHERE it.image_id = images.id AND t.name = ANY(%s))`, tagsParam))
+		}
+	}
+
+	if f.ContentType != "" {
+		condition("content_type = %s", f.ContentType)
+	}
+	if f.MinSize > 0 {
+		condition("file_size >= %s", f.MinSize)
+	}
+	if f.MaxSize > 0 {
+		condition("file_size <= %s", f.MaxSize)
+	}
+	if !f.UploadedAfter.IsZero() {
+		condition("upload_timestamp >= %s", f.UploadedAfter)
+	}
+	if !f.UploadedBefore.IsZero() {
+		condition("upload_timestamp < %s", f.UploadedBefore)
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// narrowed reports whether any filter beyond the implicit trash one is set
+func (f ImageFilters) narrowed() bool {
+	return len(f.Tags) > 0 || f.ContentType != "" || f.MinSize > 0 || f.MaxSize > 0 ||
+		!f.UploadedAfter.IsZero() || !f.UploadedBefore.IsZero() || f.Trashed
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanImage(row rowScanner, image *Image) error {
+	err := row.Scan(
+		&image.ID,
+		&image.Filename,
+		&image.OriginalFilename,
+		&image.URL,
+		&image.FileSize,
+		&image.ContentType,
+		&image.Checksum,
+		&image.Title,
+		&image.Description,
+		&image.AltText,
+		&image.TakenAt,
+		&image.DeletedAt,
+		&image.Version,
+		&image.UploadTimestamp,
+		&image.CreatedAt,
+		&image.UpdatedAt,
+		pq.Array(&image.Tags),
+	)
+	if err != nil {
+		return err
+	}
+
+	image.FileURL = imageFileURL(image.ID)
+	return nil
+}
+
+// imageFileURL is where the API streams the stored bytes of an image
+func imageFileURL(id int64) string {
+	return fmt.Sprintf("/image/%d/file", id)
 }
 
 type ImageModel struct {
@@ -33,10 +246,60 @@ type ImageModel struct {
 }
 
 func (m ImageModel) Insert(image *Image) error {
+	return m.InsertMany([]*Image{image})
+}
+
+// InsertMany inserts all images in a single transaction along with their
+// image.created events: either every row is stored or none is.
+func (m ImageModel) InsertMany(images []*Image) error {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertImages(ctx, tx, images...)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to insert images")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to commit image batch")
+		return err
+	}
+
+	m.logger.Info().Int("count", len(images)).Msg("Images inserted successfully")
+	return nil
+}
+
+// insertImages inserts new images, writes their image.created events and
+// queues their thumbnails in the transaction q
+func insertImages(ctx context.Context, q querier, images ...*Image) error {
+	for _, image := range images {
+		err := insertImage(ctx, q, image)
+		if err != nil {
+			return fmt.Errorf("%s: %w", image.Filename, err)
+		}
+	}
+
+	err := writeImageEvents(ctx, q, EventImageCreated, false, images...)
+	if err != nil {
+		return err
+	}
+
+	return enqueueThumbnailJobs(ctx, q, images...)
+}
+
+func insertImage(ctx context.Context, q querier, image *Image) error {
 	query := `
-		INSERT INTO images (filename, original_filename, url, file_size, content_type, upload_timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, updated_at`
+		INSERT INTO images (filename, original_filename, url, file_size, content_type, checksum,
+			title, description, alt_text, taken_at, upload_timestamp)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, $10, $11)
+		RETURNING id, version, created_at, updated_at`
 
 	args := []any{
 		image.Filename,
@@ -44,50 +307,63 @@ func (m ImageModel) Insert(image *Image) error {
 		image.URL,
 		image.FileSize,
 		image.ContentType,
+		image.Checksum,
+		image.Title,
+		image.Description,
+		image.AltText,
+		image.TakenAt,
 		image.UploadTimestamp,
 	}
 
-	ctx := context.Background()
-	row := m.postgresDB.QueryRowContext(ctx, query, args...)
-
-	var createdAt, updatedAt time.Time
-	err := row.Scan(&image.ID, &createdAt, &updatedAt)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.Version, &image.CreatedAt, &image.UpdatedAt)
 	if err != nil {
-		m.logger.Error().Err(err).Msg("Failed to insert image")
 		return err
 	}
 
-	m.logger.Info().
-		Int64("image_id", image.ID).
-		Str("filename", image.Filename).
-		Msg("Image inserted successfully")
-
+	image.FileURL = imageFileURL(image.ID)
+	if image.Tags == nil {
+		image.Tags = []string{}
+	}
 	return nil
 }
 
-func (m ImageModel) GetAll(limit, offset int64) ([]*Image, int64, error) {
-	var totalCount int64
-	countQuery := `SELECT COUNT(*) FROM images`
-
-	ctx := context.Background()
-	err := m.postgresDB.QueryRowContext(ctx, countQuery).Scan(&totalCount)
+func (m ImageModel) GetAll(limit, offset int64, filters ImageFilters) ([]*Image, int64, error) {
+	totalCount, _, err := m.Count(filters, CountExact)
 	if err != nil {
-		m.logger.Error().Err(err).Msg("Failed to get total image count")
 		return nil, 0, err
 	}
 
+	where, args := filters.where([]any{limit, offset})
 	query := `
-		SELECT id, filename, original_filename, url, file_size, content_type, upload_timestamp
-		FROM images 
-		ORDER BY upload_timestamp DESC 
+		SELECT ` + imageColumns + `
+		FROM images
+		` + where + `
+		` + filters.orderBy() + `
 		LIMIT $1 OFFSET $2`
 
-	args := []any{limit, offset}
+	images, err := m.queryImages(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.logger.Info().
+		Int64("total_count", totalCount).
+		Int("returned_count", len(images)).
+		Int64("limit", limit).
+		Int64("offset", offset).
+		Msg("Images retrieved successfully")
+
+	return images, totalCount, nil
+}
+
+// queryImages runs a query selecting imageColumns and scans every row
+func (m ImageModel) queryImages(query string, args ...any) ([]*Image, error) {
+	ctx := context.Background()
 
 	rows, err := m.postgresDB.QueryContext(ctx, query, args...)
 	if err != nil {
 		m.logger.Error().Err(err).Msg("Failed to query images")
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -95,64 +371,62 @@ func (m ImageModel) GetAll(limit, offset int64) ([]*Image, int64, error) {
 
 	for rows.Next() {
 		var image Image
-		err := rows.Scan(
-			&image.ID,
-			&image.Filename,
-			&image.OriginalFilename,
-			&image.URL,
-			&image.FileSize,
-			&image.ContentType,
-			&image.UploadTimestamp,
-		)
+		err := scanImage(rows, &image)
 		if err != nil {
 			m.logger.Error().Err(err).Msg("Failed to scan image row")
-			return nil, 0, err
+			return nil, err
 		}
 		images = append(images, &image)
 	}
 
 	if err = rows.Err(); err != nil {
 		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
-		return nil, 0, err
+		return nil, err
 	}
 
-	m.logger.Info().
-		Int64("total_count", totalCount).
-		Int("returned_count", len(images)).
-		Int64("limit", limit).
-		Int64("offset", offset).
-		Msg("Images retrieved successfully")
-
-	return images, totalCount, nil
+	return images, nil
 }
 
 func (m ImageModel) GetByID(id int64) (*Image, error) {
+	return m.getByID(id, false)
+}
+
+// GetByIDWithTrashed also finds images that are in the trash
+func (m ImageModel) GetByIDWithTrashed(id int64) (*Image, error) {
+	return m.getByID(id, true)
+}
+
+// GetByIDs returns the images in the order of ids, skipping ids that don't
+// exist or are in the trash
+func (m ImageModel) GetByIDs(ids []int64) ([]*Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE id = ANY($1::int[]) AND deleted_at IS NULL
+		ORDER BY array_position($1::int[], id)`
+
+	return m.queryImages(query, pq.Array(ids))
+}
+
+func (m ImageModel) getByID(id int64, withTrashed bool) (*Image, error) {
 	if id < 1 {
 		return nil, errors.New("invalid image ID")
 	}
 
 	query := `
-		SELECT id, filename, original_filename, url, file_size, content_type, upload_timestamp
+		SELECT ` + imageColumns + `
 		FROM images 
-		WHERE id = $1`
+		WHERE id = $1 AND ($2 OR deleted_at IS NULL)`
 
 	var image Image
 	ctx := context.Background()
 
-	err := m.postgresDB.QueryRowContext(ctx, query, id).Scan(
-		&image.ID,
-		&image.Filename,
-		&image.OriginalFilename,
-		&image.URL,
-		&image.FileSize,
-		&image.ContentType,
-		&image.UploadTimestamp,
-	)
+	err := scanImage(m.postgresDB.QueryRowContext(ctx, query, id, withTrashed), &image)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			m.logger.Warn().Int64("image_id", id).Msg("Image not found")
-			return nil, errors.New("record not found")
+			return nil, ErrRecordNotFound
 		}
 		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to get image by ID")
 		return nil, err
@@ -167,54 +441,205 @@ func (m ImageModel) Delete(id int64) error {
 		return errors.New("invalid image ID")
 	}
 
-	query := `DELETE FROM images WHERE id = $1`
-
 	ctx := context.Background()
-	result, err := m.postgresDB.ExecContext(ctx, query, id)
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	// the event carries the image as it was before it was deleted
+	images, err := selectImages(ctx, tx, []int64{id})
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to get image by ID")
+		return err
+	}
+
+	if len(images) == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM images WHERE id = $1`, id)
 	if err != nil {
 		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to delete image")
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = writeImageEvents(ctx, tx, EventImageDeleted, true, images...)
 	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to write image event")
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("record not found")
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to commit image deletion")
+		return err
 	}
 
 	m.logger.Info().Int64("image_id", id).Msg("Image deleted successfully")
 	return nil
 }
 
+// Trash moves an image to the trash, its row and file are kept until it is
+// restored or purged.
+func (m ImageModel) Trash(id int64) error {
+	query := `UPDATE images SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL`
+
+	err := changeImageWithEvent(m.postgresDB, EventImageDeleted, id, query, id)
+	if err != nil {
+		if !errors.Is(err, ErrRecordNotFound) {
+			m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to trash image")
+		}
+		return err
+	}
+
+	m.logger.Info().Int64("image_id", id).Msg("Image moved to trash")
+	return nil
+}
+
+// Restore takes an image out of the trash
+func (m ImageModel) Restore(id int64) error {
+	query := `UPDATE images SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	err := changeImageWithEvent(m.postgresDB, EventImageRestored, id, query, id)
+	if err != nil {
+		if !errors.Is(err, ErrRecordNotFound) {
+			m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to restore image")
+		}
+		return err
+	}
+
+	m.logger.Info().Int64("image_id", id).Msg("Image restored from trash")
+	return nil
+}
+
+// changeImageWithEvent runs a statement changing the image with the given id
+// and writes event for it in the same transaction. ErrRecordNotFound is
+// returned when the statement changed nothing.
+func changeImageWithEvent(db *sql.DB, event string, id int64, query string, args ...any) error {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = writeImageEventsByID(ctx, tx, event, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrashed permanently deletes up to limit images that were trashed
+// before the given time and returns the filenames whose files should be
+// removed from storage.
+func (m ImageModel) PurgeTrashed(before time.Time, limit int) ([]string, error) {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE deleted_at < $1
+		ORDER BY deleted_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query trashed images")
+		return nil, err
+	}
+
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		err := scanImage(rows, &image)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		images = append(images, &image)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		return []string{}, nil
+	}
+
+	ids := make([]int64, len(images))
+	filenames := make([]string, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+		filenames[i] = image.Filename
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM images WHERE id = ANY($1::int[])`, pq.Array(ids))
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to purge trashed images")
+		return nil, err
+	}
+
+	err = writeImageEvents(ctx, tx, EventImageDeleted, true, images...)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to write image events")
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to commit trash purge")
+		return nil, err
+	}
+
+	return filenames, nil
+}
+
 func (m ImageModel) GetByFilename(filename string) (*Image, error) {
 	if filename == "" {
 		return nil, errors.New("filename cannot be empty")
 	}
 
 	query := `
-		SELECT id, filename, original_filename, url, file_size, content_type, upload_timestamp
+		SELECT ` + imageColumns + `
 		FROM images 
-		WHERE filename = $1`
+		WHERE filename = $1 AND deleted_at IS NULL`
 
 	var image Image
 	ctx := context.Background()
 
-	err := m.postgresDB.QueryRowContext(ctx, query, filename).Scan(
-		&image.ID,
-		&image.Filename,
-		&image.OriginalFilename,
-		&image.URL,
-		&image.FileSize,
-		&image.ContentType,
-		&image.UploadTimestamp,
-	)
+	err := scanImage(m.postgresDB.QueryRowContext(ctx, query, filename), &image)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("record not found")
+			return nil, ErrRecordNotFound
 		}
 		m.logger.Error().Err(err).Str("filename", filename).Msg("Failed to get image by filename")
 		return nil, err
@@ -222,3 +647,123 @@ func (m ImageModel) GetByFilename(filename string) (*Image, error) {
 
 	return &image, nil
 }
+
+// ChecksumExists reports whether an image with the same content is stored
+// already, trashed images included
+func (m ImageModel) ChecksumExists(checksum string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM images WHERE checksum = $1)`
+
+	var exists bool
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, checksum).Scan(&exists)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to look up image checksum")
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// FilenameExists reports whether an image row points at the stored file
+// filename, trashed images included
+func (m ImageModel) FilenameExists(filename string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM images WHERE filename = $1)`
+
+	var exists bool
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, filename).Scan(&exists)
+	if err != nil {
+		m.logger.Error().Err(err).Str("filename", filename).Msg("Failed to look up image filename")
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// SetChecksum backfills the content hash of images stored before checksums
+// were recorded at upload time, writing an image.updated event with it.
+func (m ImageModel) SetChecksum(id int64, checksum string) error {
+	query := `UPDATE images SET checksum = $2 WHERE id = $1`
+
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id, checksum)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to set image checksum")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = writeImageEventsByID(ctx, tx, EventImageUpdated, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", id).Msg("Failed to write image event")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update saves the editable metadata of an image. It only succeeds if the
+// version is still the one that was read, otherwise ErrEditConflict is
+// returned and nothing is changed.
+func (m ImageModel) Update(image *Image) error {
+	query := `
+		UPDATE images
+		SET title = $1, description = $2, alt_text = $3, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND version = $5
+		RETURNING version, updated_at`
+
+	args := []any{
+		image.Title,
+		image.Description,
+		image.AltText,
+		image.ID,
+		image.Version,
+	}
+
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.Version, &image.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to update image")
+		return err
+	}
+
+	err = writeImageEvents(ctx, tx, EventImageUpdated, false, image)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to write image event")
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", image.ID).Msg("Failed to commit image update")
+		return err
+	}
+
+	m.logger.Info().Int64("image_id", image.ID).Int32("version", image.Version).Msg("Image updated successfully")
+	return nil
+}
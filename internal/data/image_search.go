@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+// MaxSearchTerms caps the number of words a search query is split into
+const MaxSearchTerms = 10
+
+// markers ts_headline puts around matches, replaced with <mark> once the
+// snippet has been HTML escaped
+const (
+	snippetStart = "[[mark]]"
+	snippetStop  = "[[/mark]]"
+)
+
+const snippetOptions = `StartSel="` + snippetStart + `", StopSel="` + snippetStop + `", MaxWords=20, MinWords=5, MaxFragments=2, FragmentDelimiter=" … "`
+
+// SearchResult is an image matching a search, with its relevance and an HTML
+// snippet where the matched words are wrapped in <mark>
+type SearchResult struct {
+	*Image
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchTerms splits a user query into the words that are matched against the
+// search_vector column. Anything but letters and digits separates words, so
+// no tsquery operator can get through.
+func searchTerms(q string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if len(terms) > MaxSearchTerms {
+		terms = terms[:MaxSearchTerms]
+	}
+
+	return terms
+}
+
+// searchTSQuery builds a tsquery matching images that contain every term,
+// the terms are prefix matched so "sun" finds "sunset"
+func searchTSQuery(terms []string) string {
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+func ValidateSearchQuery(v *validator.Validator, q string) {
+	v.Check(strings.TrimSpace(q) != "", "q", "must be provided")
+	v.Check(validator.MaxChars(q, 200), "q", "must not be more than 200 characters long")
+	v.Check(q == "" || len(searchTerms(q)) > 0, "q", "must contain at least one letter or digit")
+}
+
+func (m ImageModel) Search(q string, limit, offset int64) ([]*SearchResult, int64, error) {
+	tsquery := searchTSQuery(searchTerms(q))
+
+	var totalCount int64
+	countQuery := `SELECT COUNT(*) FROM images WHERE deleted_at IS NULL AND search_vector @@ to_tsquery('simple', $1)`
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, countQuery, tsquery).Scan(&totalCount)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to count search results")
+		return nil, 0, err
+	}
+
+	// rank and paginate first, so the snippets are only built for the
+	// returned page
+	query := `
+		SELECT ` + imageColumns + `, ranked.rank,
+			ts_headline('simple',
+				concat_ws(' ', NULLIF(title, ''), NULLIF(description, ''), original_filename),
+				to_tsquery('simple', $1), $4)
+		FROM (
+			SELECT id AS match_id, ts_rank_cd(search_vector, to_tsquery('simple', $1)) AS rank
+			FROM images
+			WHERE deleted_at IS NULL AND search_vector @@ to_tsquery('simple', $1)
+			ORDER BY rank DESC, upload_timestamp DESC, id DESC
+			LIMIT $2 OFFSET $3
+		) ranked
+		JOIN images ON images.id = ranked.match_id
+		ORDER BY ranked.rank DESC, upload_timestamp DESC, id DESC`
+
+	rows, err := m.postgresDB.QueryContext(ctx, query, tsquery, limit, offset, snippetOptions)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to search images")
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+
+	for rows.Next() {
+		result := SearchResult{Image: &Image{}}
+		err := scanImage(searchRowScanner{rows, &result}, result.Image)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan search result row")
+			return nil, 0, err
+		}
+		result.Snippet = highlightSnippet(result.Snippet)
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, 0, err
+	}
+
+	m.logger.Info().
+		Str("query", tsquery).
+		Int64("total_count", totalCount).
+		Int("returned_count", len(results)).
+		Msg("Images searched successfully")
+
+	return results, totalCount, nil
+}
+
+// searchRowScanner appends the rank and snippet columns to what scanImage
+// reads from a row
+type searchRowScanner struct {
+	row    rowScanner
+	result *SearchResult
+}
+
+func (s searchRowScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, &s.result.Rank, &s.result.Snippet)...)
+}
+
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStop, "</mark>")
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+)
+
+func TestSearchTSQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"sunset", "sunset:*"},
+		{"  Sunset   BEACH ", "sunset:* & beach:*"},
+		{"café 2024", "café:* & 2024:*"},
+		// tsquery operators and quotes only separate words
+		{"sun & !moon | (sea)", "sun:* & moon:* & sea:*"},
+		{"sun:* <-> moon", "sun:* & moon:*"},
+		{`it's "quoted"`, "it:* & s:* & quoted:*"},
+		{`a\b`, "a:* & b:*"},
+		{"'; DROP TABLE images; --", "drop:* & table:* & images:*"},
+		{"1 2 3 4 5 6 7 8 9 10 11 12", "1:* & 2:* & 3:* & 4:* & 5:* & 6:* & 7:* & 8:* & 9:* & 10:*"},
+		{"&|!():*", ""},
+	}
+
+	for _, tt := range tests {
+		if got := searchTSQuery(searchTerms(tt.q)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSearchQuery(t *testing.T) {
+	tests := []struct {
+		q     string
+		valid bool
+	}{
+		{"sunset", true},
+		{"", false},
+		{"   ", false},
+		{"&|!", false},
+		{strings.Repeat("a", 201), false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateSearchQuery(v, tt.q)
+		if v.Valid() != tt.valid {
+			t.Errorf("%q: got valid %v, want %v (%v)", tt.q, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	got := highlightSnippet(`<b>` + snippetStart + `sun` + snippetStop + `set & "sea"`)
+	want := `&lt;b&gt;<mark>sun</mark>set &amp; &#34;sea&#34;`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,357 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+// job states. A failed job is queued again until it runs out of attempts and
+// becomes dead, the dead-letter state it only leaves through a retry.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// the job rendering the thumbnail of an image, queued with the image in
+// the transaction inserting it. internal/jobs holds its handler.
+const (
+	JobKindImageThumbnail = "image.thumbnail"
+	DefaultJobMaxAttempts = 5
+)
+
+// ImageJobPayload is the payload of jobs about a single image
+type ImageJobPayload struct {
+	ImageID int64 `json:"image_id"`
+}
+
+var ErrJobNotDead = errors.New("job is not dead")
+
+type IJobModel interface {
+	Enqueue(kind string, payload []byte, maxAttempts int) (*Job, error)
+	Claim(kinds []string, lease time.Duration) (*Job, error)
+	Complete(id int64) error
+	Fail(job *Job) error
+	Release(id int64) error
+	Get(id int64) (*Job, error)
+	GetAll(filters JobFilters, limit, offset int64) ([]*Job, int64, error)
+	Retry(id int64) (*Job, error)
+	RetryDead(kind string) (int64, error)
+	DeleteFinished(before time.Time) (int64, error)
+}
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// JobFilters narrows down GetAll, zero values leave a filter out
+type JobFilters struct {
+	Status string
+	Kind   string
+}
+
+func ValidateJobFilters(v *validator.Validator, f JobFilters) {
+	if f.Status != "" {
+		v.Check(validator.PermittedValue(f.Status, JobQueued, JobRunning, JobSucceeded, JobDead), "status", "must be queued, running, succeeded or dead")
+	}
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+	created_at, updated_at, finished_at`
+
+func scanJob(row rowScanner, job *Job) error {
+	var payload []byte
+
+	err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	job.Payload = payload
+	return nil
+}
+
+type JobModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+func (m JobModel) Enqueue(kind string, payload []byte, maxAttempts int) (*Job, error) {
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts)
+		VALUES ($1, $2, $3)
+		RETURNING ` + jobColumns
+
+	var job Job
+	ctx := context.Background()
+
+	err := scanJob(m.postgresDB.QueryRowContext(ctx, query, kind, payload, maxAttempts), &job)
+	if err != nil {
+		m.logger.Error().Err(err).Str("kind", kind).Msg("Failed to enqueue job")
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// enqueueThumbnailJobs queues the thumbnails of images in the transaction q
+func enqueueThumbnailJobs(ctx context.Context, q querier, images ...*Image) error {
+	query := `INSERT INTO jobs (kind, payload, max_attempts) VALUES ($1, $2, $3)`
+
+	for _, image := range images {
+		payload, err := json.Marshal(ImageJobPayload{ImageID: image.ID})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, query, JobKindImageThumbnail, payload, DefaultJobMaxAttempts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Claim takes the next due job of one of the given kinds and marks it
+// running until lease is over, counting the attempt. A running job whose
+// lease ran out is taken again, its worker is assumed dead. Returns nil
+// when there is no job to run.
+func (m JobModel) Claim(kinds []string, lease time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1,
+			locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2), updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1) AND (
+				(status = 'queued' AND run_at <= CURRENT_TIMESTAMP) OR
+				(status = 'running' AND locked_until <= CURRENT_TIMESTAMP))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING ` + jobColumns
+
+	var job Job
+	ctx := context.Background()
+
+	err := scanJob(m.postgresDB.QueryRowContext(ctx, query, pq.Array(kinds), lease.Seconds()), &job)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		m.logger.Error().Err(err).Msg("Failed to claim job")
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (m JobModel) Complete(id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', locked_until = NULL, last_error = '',
+			updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("job_id", id).Msg("Failed to complete job")
+		return err
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt: job.Status is queued with job.RunAt set to
+// the time of the next attempt, or dead
+func (m JobModel) Fail(job *Job) error {
+	query := `
+		UPDATE jobs
+		SET status = $2::text, run_at = $3, last_error = $4, locked_until = NULL, updated_at = CURRENT_TIMESTAMP,
+			finished_at = CASE WHEN $2::text = 'dead' THEN CURRENT_TIMESTAMP END
+		WHERE id = $1 AND status = 'running'`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, job.ID, job.Status, job.RunAt, job.LastError)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("job_id", job.ID).Msg("Failed to record job failure")
+		return err
+	}
+
+	return nil
+}
+
+// Release queues a running job again without counting the attempt, for
+// jobs interrupted by a shutdown
+func (m JobModel) Release(id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = GREATEST(attempts - 1, 0), locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("job_id", id).Msg("Failed to release job")
+		return err
+	}
+
+	return nil
+}
+
+func (m JobModel) Get(id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	var job Job
+	ctx := context.Background()
+
+	err := scanJob(m.postgresDB.QueryRowContext(ctx, query, id), &job)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Int64("job_id", id).Msg("Failed to get job by ID")
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// GetAll lists jobs matching filters, newest first
+func (m JobModel) GetAll(filters JobFilters, limit, offset int64) ([]*Job, int64, error) {
+	query := `
+		SELECT count(*) OVER(), ` + jobColumns + `
+		FROM jobs
+		WHERE ($1::text = '' OR status = $1::text) AND ($2::text = '' OR kind = $2::text)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, filters.Status, filters.Kind, limit, offset)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query jobs")
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalCount := int64(0)
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+		err := scanJob(countScanner{rows, &totalCount}, &job)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan job row")
+			return nil, 0, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, 0, err
+	}
+
+	return jobs, totalCount, nil
+}
+
+// Retry queues a dead job again with a fresh set of attempts. It fails with
+// ErrJobNotDead for jobs in any other state.
+func (m JobModel) Retry(id int64) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = CURRENT_TIMESTAMP, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'dead'
+		RETURNING ` + jobColumns
+
+	var job Job
+	ctx := context.Background()
+
+	err := scanJob(m.postgresDB.QueryRowContext(ctx, query, id), &job)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			m.logger.Error().Err(err).Int64("job_id", id).Msg("Failed to retry job")
+			return nil, err
+		}
+
+		_, err := m.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrJobNotDead
+	}
+
+	m.logger.Info().Int64("job_id", id).Msg("Job queued again")
+	return &job, nil
+}
+
+// RetryDead queues every dead job of kind again, of all kinds when kind is
+// empty, and returns how many were queued
+func (m JobModel) RetryDead(kind string) (int64, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = CURRENT_TIMESTAMP, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'dead' AND ($1::text = '' OR kind = $1::text)`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, kind)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to retry dead jobs")
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteFinished removes jobs that succeeded before the given time, dead
+// jobs are kept until they are retried
+func (m JobModel) DeleteFinished(before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, before)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to delete finished jobs")
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
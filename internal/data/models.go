@@ -1,17 +1,44 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 
 	"github.com/rs/zerolog"
 )
 
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx so queries can be shared
+// between standalone calls and transactions.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Image IImageModel
+	Image   IImageModel
+	Upload  IUploadModel
+	Tag     ITagModel
+	Album   IAlbumModel
+	Webhook IWebhookModel
+	Outbox  IOutboxModel
+	Job     IJobModel
 }
 
 func NewModels(db *sql.DB, logger *zerolog.Logger) Models {
 	return Models{
-		Image: ImageModel{postgresDB: db, logger: logger},
+		Image:   ImageModel{postgresDB: db, logger: logger},
+		Upload:  UploadModel{postgresDB: db, logger: logger},
+		Tag:     TagModel{postgresDB: db, logger: logger},
+		Album:   AlbumModel{postgresDB: db, logger: logger},
+		Webhook: WebhookModel{postgresDB: db, logger: logger},
+		Outbox:  OutboxModel{postgresDB: db, logger: logger},
+		Job:     JobModel{postgresDB: db, logger: logger},
 	}
 }
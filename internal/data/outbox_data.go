@@ -0,0 +1,272 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+// image lifecycle events, written to the outbox by the image and tag models
+const (
+	EventImageCreated  = "image.created"
+	EventImageUpdated  = "image.updated"
+	EventImageDeleted  = "image.deleted"
+	EventImageRestored = "image.restored"
+)
+
+type IOutboxModel interface {
+	Claim(limit int, lease time.Duration) ([]*OutboxEvent, error)
+	MarkPublished(id int64) error
+	RecordFailure(event *OutboxEvent) error
+	DeletePublished(before time.Time) (int64, error)
+	LatestID() (int64, error)
+	ListAfter(after int64, skip []int64, limit int) ([]*OutboxEvent, error)
+}
+
+// OutboxEvent is an image event waiting to be published
+type OutboxEvent struct {
+	ID            int64
+	Event         string
+	ImageID       int64
+	Payload       json.RawMessage
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// ImageEventData is the payload of the image.* events
+type ImageEventData struct {
+	Image *Image `json:"image"`
+	// Permanent is set on image.deleted when the image was deleted for good
+	// instead of moved to the trash
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// writeImageEvents adds an event for each image to the outbox. q is the
+// transaction of the change, so the events are stored if and only if the
+// change is.
+func writeImageEvents(ctx context.Context, q querier, event string, permanent bool, images ...*Image) error {
+	query := `INSERT INTO outbox (event, image_id, payload) VALUES ($1, $2, $3)`
+
+	for _, image := range images {
+		payload, err := json.Marshal(ImageEventData{Image: image, Permanent: permanent})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, query, event, image.ID, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeImageEventsByID reloads the images inside the transaction, for
+// changes made with a query that doesn't return them, and writes their events
+func writeImageEventsByID(ctx context.Context, q querier, event string, ids ...int64) error {
+	images, err := selectImages(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+
+	return writeImageEvents(ctx, q, event, false, images...)
+}
+
+// selectImages loads the images with the given ids, trashed ones included
+func selectImages(ctx context.Context, q querier, ids []int64) ([]*Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE id = ANY($1::int[])
+		ORDER BY id`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*Image{}
+	for rows.Next() {
+		var image Image
+		err := scanImage(rows, &image)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, &image)
+	}
+
+	return images, rows.Err()
+}
+
+const outboxColumns = `id, event, image_id, payload, attempts, next_attempt_at, last_error, created_at`
+
+type OutboxModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+// Claim picks up to limit unpublished events that are due, oldest first, and
+// pushes their next attempt back by lease so other dispatchers skip them
+// while they are published. An event whose dispatcher died is picked up
+// again once the lease runs out.
+func (m OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	query := `
+		UPDATE outbox
+		SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING ` + outboxColumns
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to claim outbox events")
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := m.scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// UPDATE ... RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(events, func(a, b *OutboxEvent) int { return cmp.Compare(a.ID, b.ID) })
+
+	return events, nil
+}
+
+func (m OutboxModel) MarkPublished(id int64) error {
+	query := `UPDATE outbox SET published_at = CURRENT_TIMESTAMP, last_error = '' WHERE id = $1`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("outbox_id", id).Msg("Failed to mark outbox event published")
+		return err
+	}
+
+	return nil
+}
+
+// RecordFailure saves attempts, next_attempt_at and last_error of an event
+// that a sink didn't accept
+func (m OutboxModel) RecordFailure(event *OutboxEvent) error {
+	query := `
+		UPDATE outbox
+		SET attempts = $1, next_attempt_at = $2, last_error = $3
+		WHERE id = $4 AND published_at IS NULL`
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, event.Attempts, event.NextAttemptAt, event.LastError, event.ID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("outbox_id", event.ID).Msg("Failed to record outbox failure")
+		return err
+	}
+
+	return nil
+}
+
+// DeletePublished removes events published before the given time and returns
+// how many were removed
+func (m OutboxModel) DeletePublished(before time.Time) (int64, error) {
+	query := `DELETE FROM outbox WHERE published_at < $1`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, before)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to delete published outbox events")
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// LatestID returns the id of the newest event, 0 when there is none
+func (m OutboxModel) LatestID() (int64, error) {
+	query := `SELECT COALESCE(max(id), 0) FROM outbox`
+
+	var id int64
+	ctx := context.Background()
+
+	err := m.postgresDB.QueryRowContext(ctx, query).Scan(&id)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to get latest outbox id")
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ListAfter returns up to limit events with an id above after, oldest
+// first, leaving out the ids in skip. Published events are included until
+// they are deleted.
+func (m OutboxModel) ListAfter(after int64, skip []int64, limit int) ([]*OutboxEvent, error) {
+	query := `
+		SELECT ` + outboxColumns + `
+		FROM outbox
+		WHERE id > $1 AND NOT (id = ANY($2))
+		ORDER BY id
+		LIMIT $3`
+
+	if skip == nil {
+		skip = []int64{}
+	}
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, after, pq.Array(skip), limit)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query outbox events")
+		return nil, err
+	}
+	defer rows.Close()
+
+	return m.scanEvents(rows)
+}
+
+func (m OutboxModel) scanEvents(rows *sql.Rows) ([]*OutboxEvent, error) {
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		var event OutboxEvent
+		var payload []byte
+
+		err := rows.Scan(
+			&event.ID,
+			&event.Event,
+			&event.ImageID,
+			&payload,
+			&event.Attempts,
+			&event.NextAttemptAt,
+			&event.LastError,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan outbox row")
+			return nil, err
+		}
+
+		event.Payload = payload
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	return events, nil
+}
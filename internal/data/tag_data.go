@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+const MaxTagsPerRequest = 20
+
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9 _-]*$`)
+
+type ITagModel interface {
+	AddToImage(imageID int64, names []string) ([]string, error)
+	RemoveFromImage(imageID int64, name string) ([]string, error)
+	GetForImage(imageID int64) ([]string, error)
+	GetAllWithCounts() ([]*TagCount, error)
+}
+
+type TagCount struct {
+	Name       string `json:"name"`
+	ImageCount int64  `json:"image_count"`
+}
+
+// NormalizeTag lower-cases a tag and collapses surrounding whitespace so
+// "Holiday " and "holiday" end up as the same tag
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func ValidateTags(v *validator.Validator, names []string) {
+	v.Check(len(names) > 0, "tags", "must contain at least one tag")
+	v.Check(len(names) <= MaxTagsPerRequest, "tags", "must not contain more than 20 tags")
+
+	for _, name := range names {
+		v.Check(name != "", "tags", "must not contain empty tags")
+		v.Check(validator.MaxChars(name, 50), "tags", "must not contain tags longer than 50 characters")
+		v.Check(name == "" || TagRX.MatchString(name), "tags", "may only contain letters, digits, spaces, '-' and '_'")
+	}
+}
+
+type TagModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+// attachTagsQuery creates the missing tags of $2 and attaches all of them to
+// every image in $1. Rows inserted by the CTE are not visible to the SELECT
+// on tags, hence the UNION with new_tags.
+const attachTagsQuery = `
+	WITH new_tags AS (
+		INSERT INTO tags (name)
+		SELECT unnest($2::text[])
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id
+	)
+	INSERT INTO image_tags (image_id, tag_id)
+	SELECT image_id, tag_id
+	FROM unnest($1::int[]) AS image_id
+	CROSS JOIN (
+		SELECT id AS tag_id FROM tags WHERE name = ANY($2)
+		UNION
+		SELECT id FROM new_tags
+	) t
+	ON CONFLICT DO NOTHING`
+
+// AddToImage creates missing tags and attaches all of them to the image,
+// returning the image's tags afterwards. Tags already on the image are
+// ignored.
+func (m TagModel) AddToImage(imageID int64, names []string) ([]string, error) {
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, attachTagsQuery, pq.Array([]int64{imageID}), pq.Array(names))
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", imageID).Msg("Failed to add tags to image")
+		return nil, err
+	}
+
+	err = writeImageEventsByID(ctx, tx, EventImageUpdated, imageID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", imageID).Msg("Failed to write image event")
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", imageID).Msg("Failed to commit image tags")
+		return nil, err
+	}
+
+	m.logger.Info().Int64("image_id", imageID).Strs("tags", names).Msg("Tags added successfully")
+
+	return m.GetForImage(imageID)
+}
+
+func (m TagModel) RemoveFromImage(imageID int64, name string) ([]string, error) {
+	query := `
+		DELETE FROM image_tags
+		USING tags
+		WHERE image_tags.tag_id = tags.id AND image_tags.image_id = $1 AND tags.name = $2`
+
+	err := changeImageWithEvent(m.postgresDB, EventImageUpdated, imageID, query, imageID, name)
+	if err != nil {
+		if !errors.Is(err, ErrRecordNotFound) {
+			m.logger.Error().Err(err).Int64("image_id", imageID).Msg("Failed to remove tag from image")
+		}
+		return nil, err
+	}
+
+	m.logger.Info().Int64("image_id", imageID).Str("tag", name).Msg("Tag removed successfully")
+
+	return m.GetForImage(imageID)
+}
+
+func (m TagModel) GetForImage(imageID int64) ([]string, error) {
+	query := `
+		SELECT t.name
+		FROM image_tags it
+		JOIN tags t ON t.id = it.tag_id
+		WHERE it.image_id = $1
+		ORDER BY t.name`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, imageID)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("image_id", imageID).Msg("Failed to query image tags")
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// GetAllWithCounts lists every tag with the number of images carrying it,
+// most used first
+func (m TagModel) GetAllWithCounts() ([]*TagCount, error) {
+	query := `
+		SELECT t.name, COUNT(i.id)
+		FROM tags t
+		LEFT JOIN image_tags it ON it.tag_id = t.id
+		LEFT JOIN images i ON i.id = it.image_id AND i.deleted_at IS NULL
+		GROUP BY t.id, t.name
+		ORDER BY COUNT(i.id) DESC, t.name`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query tags")
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		err := rows.Scan(&tag.Name, &tag.ImageCount)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan tag row")
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	return tags, nil
+}
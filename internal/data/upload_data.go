@@ -0,0 +1,208 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// IUploadModel keeps track of resumable (tus) uploads that have not been
+// turned into an image yet.
+type IUploadModel interface {
+	Insert(upload *Upload) error
+	Get(id string) (*Upload, error)
+	UpdateOffset(id string, expectedOffset, newOffset int64) error
+	Complete(id string, image *Image) error
+	Delete(id string) error
+}
+
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	ImageID   *int64            `json:"image_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Completed reports whether every byte of the upload has been received.
+func (u *Upload) Completed() bool {
+	return u.Offset == u.Length
+}
+
+type UploadModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+func (m UploadModel) Insert(upload *Upload) error {
+	metadata, err := json.Marshal(upload.Metadata)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO tus_uploads (id, upload_length, upload_offset, metadata)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at, updated_at`
+
+	args := []any{upload.ID, upload.Length, upload.Offset, metadata}
+
+	ctx := context.Background()
+	err = m.postgresDB.QueryRowContext(ctx, query, args...).Scan(&upload.CreatedAt, &upload.UpdatedAt)
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", upload.ID).Msg("Failed to insert upload")
+		return err
+	}
+
+	m.logger.Info().
+		Str("upload_id", upload.ID).
+		Int64("upload_length", upload.Length).
+		Msg("Upload created successfully")
+
+	return nil
+}
+
+func (m UploadModel) Get(id string) (*Upload, error) {
+	query := `
+		SELECT id, upload_length, upload_offset, metadata, image_id, created_at, updated_at
+		FROM tus_uploads
+		WHERE id = $1`
+
+	var upload Upload
+	var metadata []byte
+	var imageID sql.NullInt64
+	ctx := context.Background()
+
+	err := m.postgresDB.QueryRowContext(ctx, query, id).Scan(
+		&upload.ID,
+		&upload.Length,
+		&upload.Offset,
+		&metadata,
+		&imageID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to get upload")
+		return nil, err
+	}
+
+	err = json.Unmarshal(metadata, &upload.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if imageID.Valid {
+		upload.ImageID = &imageID.Int64
+	}
+
+	return &upload, nil
+}
+
+// UpdateOffset moves the offset forward only if nobody else has done so in
+// the meantime, so two concurrent PATCH requests can't both win.
+func (m UploadModel) UpdateOffset(id string, expectedOffset, newOffset int64) error {
+	query := `
+		UPDATE tus_uploads
+		SET upload_offset = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND upload_offset = $2 AND image_id IS NULL`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, id, expectedOffset, newOffset)
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to update upload offset")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Complete inserts the image assembled from an upload and links it to the
+// upload in one transaction, so an upload is never left complete without its
+// image, nor an image stored twice for one upload. It fails with
+// ErrEditConflict when the upload was completed already.
+func (m UploadModel) Complete(id string, image *Image) error {
+	query := `
+		UPDATE tus_uploads
+		SET image_id = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND image_id IS NULL`
+
+	ctx := context.Background()
+	tx, err := m.postgresDB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to begin transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertImages(ctx, tx, image)
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to insert image of upload")
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, id, image.ID)
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to complete upload")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to commit upload")
+		return err
+	}
+
+	m.logger.Info().Str("upload_id", id).Int64("image_id", image.ID).Msg("Upload completed successfully")
+	return nil
+}
+
+func (m UploadModel) Delete(id string) error {
+	query := `DELETE FROM tus_uploads WHERE id = $1`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Str("upload_id", id).Msg("Failed to delete upload")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.logger.Info().Str("upload_id", id).Msg("Upload deleted successfully")
+	return nil
+}
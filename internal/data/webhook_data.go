@@ -0,0 +1,474 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/validator"
+	"github.com/khofesh/img-upload-view/pkg/safefetch"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{EventImageCreated, EventImageUpdated, EventImageDeleted, EventImageRestored}
+
+// delivery states, a delivery stays pending until it succeeds or runs out of
+// attempts
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type IWebhookModel interface {
+	Insert(webhook *Webhook) error
+	GetAll() ([]*Webhook, error)
+	Get(id int64) (*Webhook, error)
+	Update(webhook *Webhook) error
+	Delete(id int64) error
+	Enqueue(event string, payload []byte) (int64, error)
+	ClaimDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordAttempt(delivery *WebhookDelivery) error
+	GetDeliveries(webhookID, limit, offset int64) ([]*WebhookDelivery, int64, error)
+	Redeliver(webhookID, deliveryID int64) (*WebhookDelivery, error)
+}
+
+type Webhook struct {
+	ID     int64  `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"-"`
+	// Events the webhook receives, empty for all of them
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID        int64           `json:"id"`
+	WebhookID int64           `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	// NextAttemptAt is only meaningful while the delivery is pending
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	ResponseStatus *int       `json:"response_status"`
+	LastError      string     `json:"last_error,omitempty"`
+	RedeliveryOf   *int64     `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// target of the delivery, set by ClaimDeliveries
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook, allowPrivateNetworks bool) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(validator.MaxChars(webhook.URL, 2000), "url", "must not be more than 2000 characters long")
+
+	if u, err := url.Parse(webhook.URL); webhook.URL != "" {
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+		if err == nil && !allowPrivateNetworks {
+			v.Check(safefetch.AllowedHost(u.Hostname()), "url", "must not point at a loopback or private address")
+		}
+	}
+
+	for _, event := range webhook.Events {
+		v.Check(validator.PermittedValue(event, WebhookEvents...), "events", "must only contain known events")
+	}
+
+	sorted := slices.Clone(webhook.Events)
+	slices.Sort(sorted)
+	v.Check(len(slices.Compact(sorted)) == len(webhook.Events), "events", "must not contain duplicate events")
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 characters long")
+	v.Check(validator.MaxChars(webhook.Secret, 200), "secret", "must not be more than 200 characters long")
+}
+
+const webhookColumns = `id, url, secret, events, active, version, created_at, updated_at`
+
+func scanWebhook(row rowScanner, webhook *Webhook) error {
+	return row.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	)
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_attempt_at, d.response_status, d.last_error, d.redelivery_of, d.created_at`
+
+func scanDelivery(row rowScanner, delivery *WebhookDelivery, extra ...any) error {
+	var payload []byte
+	var responseStatus sql.NullInt32
+	var redeliveryOf sql.NullInt64
+
+	dest := []any{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&responseStatus,
+		&delivery.LastError,
+		&redeliveryOf,
+		&delivery.CreatedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return err
+	}
+
+	delivery.Payload = payload
+
+	delivery.ResponseStatus = nil
+	if responseStatus.Valid {
+		status := int(responseStatus.Int32)
+		delivery.ResponseStatus = &status
+	}
+
+	delivery.RedeliveryOf = nil
+	if redeliveryOf.Valid {
+		delivery.RedeliveryOf = &redeliveryOf.Int64
+	}
+
+	return nil
+}
+
+type WebhookModel struct {
+	postgresDB *sql.DB
+	logger     *zerolog.Logger
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, version, created_at, updated_at`
+
+	// a nil slice would be stored as NULL
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	args := []any{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, args...).Scan(
+		&webhook.ID,
+		&webhook.Version,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to insert webhook")
+		return err
+	}
+
+	m.logger.Info().Int64("webhook_id", webhook.ID).Str("url", webhook.URL).Msg("Webhook inserted successfully")
+	return nil
+}
+
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		ORDER BY id`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to query webhooks")
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := scanWebhook(rows, &webhook)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan webhook row")
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE id = $1`
+
+	var webhook Webhook
+	ctx := context.Background()
+
+	err := scanWebhook(m.postgresDB.QueryRowContext(ctx, query, id), &webhook)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Int64("webhook_id", id).Msg("Failed to get webhook by ID")
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// Update saves url, secret, events and active, failing with ErrEditConflict
+// if the webhook changed since it was read
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, secret = $2, events = $3, active = $4, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND version = $6
+		RETURNING version, updated_at`
+
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	args := []any{
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.Events),
+		webhook.Active,
+		webhook.ID,
+		webhook.Version,
+	}
+
+	ctx := context.Background()
+	err := m.postgresDB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version, &webhook.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		m.logger.Error().Err(err).Int64("webhook_id", webhook.ID).Msg("Failed to update webhook")
+		return err
+	}
+
+	m.logger.Info().Int64("webhook_id", webhook.ID).Msg("Webhook updated successfully")
+	return nil
+}
+
+// Delete removes the webhook and its delivery log
+func (m WebhookModel) Delete(id int64) error {
+	query := `DELETE FROM webhooks WHERE id = $1`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, id)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("webhook_id", id).Msg("Failed to delete webhook")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	m.logger.Info().Int64("webhook_id", id).Msg("Webhook deleted successfully")
+	return nil
+}
+
+// Enqueue creates a pending delivery of payload for every active webhook
+// subscribed to event and returns how many were created
+func (m WebhookModel) Enqueue(event string, payload []byte) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $1::text, $2::jsonb
+		FROM webhooks
+		WHERE active AND (events = '{}' OR $1::text = ANY(events))`
+
+	ctx := context.Background()
+	result, err := m.postgresDB.ExecContext(ctx, query, event, payload)
+	if err != nil {
+		m.logger.Error().Err(err).Str("event", event).Msg("Failed to enqueue webhook deliveries")
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDeliveries picks up to limit due deliveries of active webhooks and
+// pushes their next attempt back by lease, so other workers skip them and
+// they are picked up again if this worker dies before recording the attempt.
+func (m WebhookModel) ClaimDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT pd.id
+			FROM webhook_deliveries pd
+			JOIN webhooks pw ON pw.id = pd.webhook_id
+			WHERE pd.status = 'pending' AND pd.next_attempt_at <= CURRENT_TIMESTAMP AND pw.active
+			ORDER BY pd.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF pd SKIP LOCKED)
+		RETURNING ` + deliveryColumns + `, w.url, w.secret`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to claim webhook deliveries")
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(rows, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan webhook delivery row")
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt saves the outcome of an attempt: status, attempts, the
+// response and when to try next
+func (m WebhookModel) RecordAttempt(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = $4, response_status = $5, last_error = $6
+		WHERE id = $7`
+
+	args := []any{
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastAttemptAt,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.ID,
+	}
+
+	ctx := context.Background()
+	_, err := m.postgresDB.ExecContext(ctx, query, args...)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("delivery_id", delivery.ID).Msg("Failed to record webhook delivery attempt")
+		return err
+	}
+
+	return nil
+}
+
+// GetDeliveries returns the delivery log of a webhook, newest first
+func (m WebhookModel) GetDeliveries(webhookID, limit, offset int64) ([]*WebhookDelivery, int64, error) {
+	query := `
+		SELECT count(*) OVER(), ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.webhook_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2 OFFSET $3`
+
+	ctx := context.Background()
+	rows, err := m.postgresDB.QueryContext(ctx, query, webhookID, limit, offset)
+	if err != nil {
+		m.logger.Error().Err(err).Int64("webhook_id", webhookID).Msg("Failed to query webhook deliveries")
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalCount := int64(0)
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(countScanner{rows, &totalCount}, &delivery)
+		if err != nil {
+			m.logger.Error().Err(err).Msg("Failed to scan webhook delivery row")
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		m.logger.Error().Err(err).Msg("Error occurred during row iteration")
+		return nil, 0, err
+	}
+
+	return deliveries, totalCount, nil
+}
+
+// Redeliver queues a copy of a delivery of the webhook, the original stays
+// in the log as it was
+func (m WebhookModel) Redeliver(webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, redelivery_of)
+		SELECT webhook_id, event, payload, id
+		FROM webhook_deliveries
+		WHERE id = $1 AND webhook_id = $2
+		RETURNING id, webhook_id, event, payload, status, attempts, next_attempt_at,
+			last_attempt_at, response_status, last_error, redelivery_of, created_at`
+
+	var delivery WebhookDelivery
+	ctx := context.Background()
+
+	err := scanDelivery(m.postgresDB.QueryRowContext(ctx, query, deliveryID, webhookID), &delivery)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		m.logger.Error().Err(err).Int64("delivery_id", deliveryID).Msg("Failed to redeliver webhook delivery")
+		return nil, err
+	}
+
+	m.logger.Info().Int64("delivery_id", delivery.ID).Int64("redelivery_of", deliveryID).Msg("Webhook delivery queued again")
+	return &delivery, nil
+}
+
+// countScanner scans a leading count(*) OVER() column into count before the
+// columns of the row
+type countScanner struct {
+	row   rowScanner
+	count *int64
+}
+
+func (s countScanner) Scan(dest ...any) error {
+	return s.row.Scan(append([]any{s.count}, dest...)...)
+}
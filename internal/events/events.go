@@ -0,0 +1,92 @@
+// Package events fans image events out to the clients streaming them from
+// GET /events. Each API instance runs one Hub, fed from the outbox table.
+package events
+
+import (
+	"sync"
+
+	"github.com/khofesh/img-upload-view/internal/outbox"
+)
+
+// subscriptionBuffer is how many messages a subscriber may fall behind
+// before it is dropped
+const subscriptionBuffer = 64
+
+// Hub broadcasts messages to its subscribers. A subscriber that doesn't keep
+// up is dropped rather than slowing down the others, it reconnects and
+// catches up from the outbox with Last-Event-ID.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the messages published after Subscribe. C is
+// closed when the subscriber was dropped or the hub closed.
+type Subscription struct {
+	C <-chan outbox.Message
+
+	c   chan outbox.Message
+	hub *Hub
+}
+
+// Subscribe adds a subscriber. ok is false once the hub is closed.
+func (h *Hub) Subscribe() (sub *Subscription, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, false
+	}
+
+	c := make(chan outbox.Message, subscriptionBuffer)
+	sub = &Subscription{C: c, c: c, hub: h}
+	h.subs[sub] = struct{}{}
+
+	return sub, true
+}
+
+// Close removes the subscriber, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Publish hands msg to every subscriber without waiting for any of them
+func (h *Hub) Publish(msg outbox.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub.c <- msg:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// Close drops every subscriber and refuses new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		h.remove(sub)
+	}
+}
+
+// remove closes the channel of sub, h.mu must be held
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
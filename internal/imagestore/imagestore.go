@@ -0,0 +1,101 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/storage"
+	"github.com/khofesh/img-upload-view/pkg/exif"
+)
+
+const MaxUploadSize = 10 << 20
+
+// exifHeadSize is how much of an upload is kept to look for EXIF data, which
+// sits in an APP1 segment of at most 64KB near the start of the file
+const exifHeadSize = 128 * 1024
+
+// ValidateFile holds the rules every stored image has to pass, whichever way
+// it comes in
+func ValidateFile(size int64, contentType string) error {
+	if size > MaxUploadSize {
+		return fmt.Errorf("file size exceeds 10MB limit")
+	}
+
+	if contentType != "image/jpeg" && contentType != "image/jpg" {
+		return fmt.Errorf("only JPEG images are allowed")
+	}
+
+	return nil
+}
+
+// Put copies the image bytes into storage under a unique filename and
+// returns the metadata to be inserted.
+func Put(ctx context.Context, st storage.Storage, src io.Reader, originalFilename, contentType string) (*data.Image, error) {
+	return PutAs(ctx, st, UniqueFilename(originalFilename), src, originalFilename, contentType)
+}
+
+// PutAs is Put with a filename from UniqueFilename chosen beforehand, for
+// callers that record it before the file exists
+func PutAs(ctx context.Context, st storage.Storage, uniqueFilename string, src io.Reader, originalFilename, contentType string) (*data.Image, error) {
+	// NOTE: we could instead send the image data to GCP cloud storage
+	// or AWS S3 bucket
+	hash := sha256.New()
+	head := &headWriter{max: exifHeadSize}
+	size, err := st.Put(ctx, uniqueFilename, io.TeeReader(src, io.MultiWriter(hash, head)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to save file: %v", err)
+	}
+
+	// construct image metadata
+	imageData := &data.Image{
+		Filename:         uniqueFilename,
+		OriginalFilename: originalFilename,
+		URL:              fmt.Sprintf("/images/%s", uniqueFilename),
+		FileSize:         size,
+		ContentType:      contentType,
+		Checksum:         hex.EncodeToString(hash.Sum(nil)),
+		UploadTimestamp:  time.Now(),
+	}
+
+	// EXIF is read here rather than in a job: it only looks at the head kept
+	// while the file was streamed to storage, and taken_at is then part of
+	// the response and of the image.created event
+	if takenAt, err := exif.DateTimeOriginal(head.buf); err == nil {
+		imageData.TakenAt = &takenAt
+	}
+
+	return imageData, nil
+}
+
+// headWriter keeps the first max bytes written to it
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	if n := h.max - len(h.buf); n > 0 {
+		h.buf = append(h.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// UniqueFilename returns a new storage key for an image, keeping the
+// extension of originalFilename
+func UniqueFilename(originalFilename string) string {
+	ext := filepath.Ext(originalFilename)
+	timestamp := time.Now().Unix()
+
+	randomBytes := make([]byte, 8)
+	rand.Read(randomBytes)
+	randomString := hex.EncodeToString(randomBytes)
+
+	return fmt.Sprintf("%d_%s%s", timestamp, randomString, ext)
+}
@@ -0,0 +1,97 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// Sidecars are small JSON files holding the metadata of a stored image, kept
+// in storage under "sidecars/<filename>.json". They make the images table
+// recoverable from the storage backend alone.
+const (
+	sidecarPrefix  = "sidecars/"
+	sidecarSuffix  = ".json"
+	sidecarVersion = 1
+)
+
+type sidecar struct {
+	SidecarVersion int `json:"sidecar_version"`
+	*data.Image
+}
+
+// SidecarKey returns the storage key of the sidecar of a stored file
+func SidecarKey(filename string) string {
+	return sidecarPrefix + filename + sidecarSuffix
+}
+
+// WriteSidecar stores the current metadata of image, replacing the previous
+// sidecar
+func WriteSidecar(ctx context.Context, st storage.Storage, image *data.Image) error {
+	js, err := json.Marshal(sidecar{SidecarVersion: sidecarVersion, Image: image})
+	if err != nil {
+		return err
+	}
+
+	_, err = st.Put(ctx, SidecarKey(image.Filename), bytes.NewReader(js))
+	return err
+}
+
+// ReadSidecar loads the image metadata stored under key
+func ReadSidecar(ctx context.Context, st storage.Storage, key string) (*data.Image, error) {
+	obj, err := st.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	s := sidecar{Image: &data.Image{}}
+	err = json.NewDecoder(obj).Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sidecar %s: %v", key, err)
+	}
+
+	if s.SidecarVersion != sidecarVersion {
+		return nil, fmt.Errorf("sidecar %s has unsupported version %d", key, s.SidecarVersion)
+	}
+
+	if s.ID < 1 || s.Filename == "" || SidecarKey(s.Filename) != key {
+		return nil, fmt.Errorf("sidecar %s does not describe a stored image", key)
+	}
+
+	return s.Image, nil
+}
+
+// ListSidecars returns the keys of all sidecars in storage
+func ListSidecars(ctx context.Context, st storage.Storage) ([]string, error) {
+	keys, err := st.List(ctx, sidecarPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := []string{}
+	for _, key := range keys {
+		if strings.HasSuffix(key, sidecarSuffix) {
+			sidecars = append(sidecars, key)
+		}
+	}
+
+	return sidecars, nil
+}
+
+// DeleteSidecar removes the sidecar of a stored file, a missing one is not an
+// error
+func DeleteSidecar(ctx context.Context, st storage.Storage, filename string) error {
+	err := st.Delete(ctx, SidecarKey(filename))
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package imagestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	"github.com/khofesh/img-upload-view/internal/storage"
+)
+
+// Thumbnails are JPEGs of at most ThumbnailSize pixels on the longer side,
+// kept in storage under "thumbnails/<filename>"
+const (
+	ThumbnailSize    = 320
+	thumbnailPrefix  = "thumbnails/"
+	thumbnailQuality = 80
+
+	// a small JPEG can claim huge dimensions, larger images are not decoded
+	MaxThumbnailSourcePixels = 50_000_000
+)
+
+// ErrTooManyPixels is returned for images larger than
+// MaxThumbnailSourcePixels, trying again doesn't help
+var ErrTooManyPixels = errors.New("image has too many pixels for a thumbnail")
+
+// ThumbnailKey returns the storage key of the thumbnail of a stored file
+func ThumbnailKey(filename string) string {
+	return thumbnailPrefix + filename
+}
+
+// WriteThumbnail decodes the stored file and stores its thumbnail, replacing
+// a previous one. Images smaller than a thumbnail are only re-encoded.
+func WriteThumbnail(ctx context.Context, st storage.Storage, filename string) error {
+	obj, err := st.Open(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	cfg, err := jpeg.DecodeConfig(obj)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s: %w", filename, err)
+	}
+
+	if int64(cfg.Width)*int64(cfg.Height) > MaxThumbnailSourcePixels {
+		return fmt.Errorf("%s is %dx%d: %w", filename, cfg.Width, cfg.Height, ErrTooManyPixels)
+	}
+
+	_, err = obj.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	src, err := jpeg.Decode(obj)
+	if err != nil {
+		return fmt.Errorf("unable to decode %s: %w", filename, err)
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, scaleDown(src, ThumbnailSize), &jpeg.Options{Quality: thumbnailQuality})
+	if err != nil {
+		return err
+	}
+
+	_, err = st.Put(ctx, ThumbnailKey(filename), &buf)
+	return err
+}
+
+// DeleteThumbnail removes the thumbnail of a stored file, if it has one
+func DeleteThumbnail(ctx context.Context, st storage.Storage, filename string) error {
+	err := st.Delete(ctx, ThumbnailKey(filename))
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+// scaleDown fits src into a size x size box, averaging the source pixels
+// behind each target pixel
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := range th {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := range tw {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+
+			var r, g, bl, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					r, g, bl, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), n+1
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,100 @@
+// Package jobs defines the kinds of background jobs and the registry of
+// their handlers. Jobs are stored in Postgres and run by the workers of the
+// API.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+)
+
+// DefaultMaxAttempts is how often a job runs before it is dead
+const DefaultMaxAttempts = data.DefaultJobMaxAttempts
+
+// Kind names a kind of job whose payload is a T
+type Kind[T any] string
+
+// ImagePayload - jobs about a single image, defined in data which queues
+// thumbnails along with new images
+type ImagePayload = data.ImageJobPayload
+
+// kinds of jobs
+const (
+	// queued by the image model along with every new image
+	ImageThumbnail Kind[ImagePayload] = data.JobKindImageThumbnail
+)
+
+// Enqueue stores a job of kind to be run by a worker
+func Enqueue[T any](model data.IJobModel, kind Kind[T], payload T) (*data.Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.Enqueue(string(kind), js, DefaultMaxAttempts)
+}
+
+// Registry maps kinds to their handlers. Workers only claim jobs of
+// registered kinds.
+type Registry struct {
+	handlers map[string]func(ctx context.Context, payload json.RawMessage) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]func(ctx context.Context, payload json.RawMessage) error{}}
+}
+
+// Register sets the handler of kind, replacing a previous one
+func Register[T any](r *Registry, kind Kind[T], handler func(ctx context.Context, payload T) error) {
+	r.handlers[string(kind)] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		err := json.Unmarshal(raw, &payload)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid payload: %v", err))
+		}
+		return handler(ctx, payload)
+	}
+}
+
+// Kinds returns the registered kinds, sorted
+func (r *Registry) Kinds() []string {
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// Run passes the payload of job to the handler of its kind
+func (r *Registry) Run(ctx context.Context, job *data.Job) error {
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	}
+	return handler(ctx, job.Payload)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying won't fix, the job is dead right
+// away
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
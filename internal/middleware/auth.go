@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/presign"
+)
+
+// room for multipart boundaries and part headers on top of the signed
+// maximum file size
+const presignBodyOverhead = 1 << 20
+
+// RequireAuthentication lets a request through if it carries a valid
+// presigned URL signature or a configured API key as bearer token. When no
+// API keys are configured the API stays open, but a signature that is
+// present is still verified.
+func (m *Middlewares[T]) RequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has(presign.SignatureParam) {
+			m.verifySignedURL(next, w, r)
+			return
+		}
+
+		m.RequireAPIKey(next).ServeHTTP(w, r)
+	})
+}
+
+// RequireAPIKey only accepts requests carrying one of the configured API
+// or admin keys, presigned URLs are not enough. Without API keys configured
+// every request is let through, only an admin key makes a difference then.
+func (m *Middlewares[T]) RequireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+
+		switch {
+		case ok && validKey(m.adminAPIKeys, token):
+			next.ServeHTTP(w, r.WithContext(contextWithAdmin(r.Context())))
+		case m.open():
+			next.ServeHTTP(w, r)
+		case authorizationHeader == "":
+			m.errorResponse.AuthenticationRequiredResponse(w, r)
+		case ok && validKey(m.apiKeys, token):
+			next.ServeHTTP(w, r)
+		default:
+			m.errorResponse.InvalidAuthenticationTokenResponse(w, r)
+		}
+	})
+}
+
+// RequireKey only accepts requests carrying one of the configured API or
+// admin keys, even when the API is open. It guards POST /presign: a signed
+// URL must be issued to someone holding a key.
+func (m *Middlewares[T]) RequireKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		token, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+
+		switch {
+		case ok && validKey(m.adminAPIKeys, token):
+			next.ServeHTTP(w, r.WithContext(contextWithAdmin(r.Context())))
+		case ok && validKey(m.apiKeys, token):
+			next.ServeHTTP(w, r)
+		case authorizationHeader == "":
+			m.errorResponse.AuthenticationRequiredResponse(w, r)
+		default:
+			m.errorResponse.InvalidAuthenticationTokenResponse(w, r)
+		}
+	})
+}
+
+func (m *Middlewares[T]) verifySignedURL(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if m.signer == nil {
+		m.errorResponse.ErrorResponse(w, r, http.StatusForbidden, "presigned URLs are not enabled")
+		return
+	}
+
+	params, err := m.signer.Verify(r.Method, r.URL.Path, r.URL.Query(), time.Now())
+	if err != nil {
+		message := "invalid presigned URL signature"
+		if errors.Is(err, presign.ErrExpired) {
+			message = "presigned URL has expired"
+		}
+		m.errorResponse.ErrorResponse(w, r, http.StatusForbidden, message)
+		return
+	}
+
+	if params.MaxSize > 0 {
+		if r.ContentLength > params.MaxSize+presignBodyOverhead {
+			m.errorResponse.ErrorResponse(w, r, http.StatusRequestEntityTooLarge, "request body exceeds the signed size limit")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, params.MaxSize+presignBodyOverhead)
+	}
+
+	next.ServeHTTP(w, r.WithContext(presign.ContextWithParams(r.Context(), params)))
+}
+
+// open reports whether no API keys are configured, in which case callers
+// without a bearer token may read and write. Admin rights always need one of
+// the admin keys.
+func (m *Middlewares[T]) open() bool {
+	return len(m.apiKeys) == 0
+}
+
+func validKey(keys []string, token string) bool {
+	valid := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+type adminContextKey struct{}
+
+func contextWithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminContextKey{}, true)
+}
+
+// IsAdmin reports whether the request was authenticated with an admin key
+func IsAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminContextKey{}).(bool)
+	return admin
+}
+
+// RequireAdmin only accepts requests authenticated with an admin API key.
+// Without admin keys configured, admin operations are forbidden.
+func (m *Middlewares[T]) RequireAdmin(next http.Handler) http.Handler {
+	return m.RequireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r) {
+			m.errorResponse.NotPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
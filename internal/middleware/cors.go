@@ -4,6 +4,15 @@ import (
 	"net/http"
 )
 
+// headers the browser may send on cross-origin requests, including the ones
+// used by tus clients for resumable uploads
+const allowedHeaders = "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, If-Match, " +
+	"Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Defer-Length"
+
+// response headers readable by front-end code
+const exposedHeaders = "ETag, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, " +
+	"Upload-Offset, Upload-Length, Upload-Image-Id"
+
 // EnableCORS is a middleware to enable CORS
 func (m *Middlewares[T]) EnableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -21,14 +30,15 @@ func (m *Middlewares[T]) EnableCORS(next http.Handler) http.Handler {
 				if origin == m.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 					w.Header().Set("Access-Control-Allow-Credentials", "true") // for httpOnly cookie
+					w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						// Set the necessary preflight response headers
-						w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization")
+						w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, HEAD, DELETE")
+						w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"github.com/khofesh/img-upload-view/internal/presign"
 	errres "github.com/khofesh/img-upload-view/pkg/errors"
 	"github.com/rs/zerolog"
 )
@@ -9,6 +10,9 @@ type Middlewares[T any] struct {
 	errorResponse  errres.ErrorResponse
 	trustedOrigins []string
 	logger         *zerolog.Logger
+	apiKeys        []string
+	adminAPIKeys   []string
+	signer         *presign.Signer
 }
 
 type Option[T any] func(*Middlewares[T])
@@ -31,6 +35,25 @@ func WithTrustedOrigins[T any](trustedOrigins []string) Option[T] {
 	}
 }
 
+func WithAPIKeys[T any](apiKeys []string) Option[T] {
+	return func(m *Middlewares[T]) {
+		m.apiKeys = apiKeys
+	}
+}
+
+// WithAdminAPIKeys sets the keys that also grant admin only operations
+func WithAdminAPIKeys[T any](adminAPIKeys []string) Option[T] {
+	return func(m *Middlewares[T]) {
+		m.adminAPIKeys = adminAPIKeys
+	}
+}
+
+func WithSigner[T any](signer *presign.Signer) Option[T] {
+	return func(m *Middlewares[T]) {
+		m.signer = signer
+	}
+}
+
 func New[T any](opts ...Option[T]) Middlewares[T] {
 	m := Middlewares[T]{}
 	for _, opt := range opts {
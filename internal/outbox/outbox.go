@@ -0,0 +1,75 @@
+// Package outbox holds the sinks the image events of the outbox table are
+// published to.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/khofesh/img-upload-view/internal/data"
+	"github.com/rs/zerolog"
+)
+
+// Message is the JSON form of an event as sinks publish it. ID is the id of
+// the outbox row, it stays the same when the event is published again, so
+// consumers can drop duplicates.
+type Message struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func NewMessage(event *data.OutboxEvent) Message {
+	return Message{
+		ID:        event.ID,
+		Type:      event.Event,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	}
+}
+
+// Sink publishes messages somewhere. Publish only returns nil once the
+// message was accepted, a message may be published more than once.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, msg Message) error
+}
+
+// WebhookSink queues a delivery of every message to the subscribed webhooks
+type WebhookSink struct {
+	Webhooks data.IWebhookModel
+}
+
+func (s WebhookSink) Name() string {
+	return "webhooks"
+}
+
+func (s WebhookSink) Publish(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Webhooks.Enqueue(msg.Type, body)
+	return err
+}
+
+// LogSink writes every message to the log, for development and debugging
+type LogSink struct {
+	Logger *zerolog.Logger
+}
+
+func (s LogSink) Name() string {
+	return "log"
+}
+
+func (s LogSink) Publish(ctx context.Context, msg Message) error {
+	s.Logger.Info().
+		Int64("event_id", msg.ID).
+		Str("event", msg.Type).
+		RawJSON("data", msg.Data).
+		Msg("image event")
+	return nil
+}
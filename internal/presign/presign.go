@@ -0,0 +1,153 @@
+package presign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query parameters carried by a presigned URL
+const (
+	KeyIDParam       = "key_id"
+	ExpiresParam     = "expires"
+	MaxSizeParam     = "max_size"
+	ContentTypeParam = "content_type"
+	SignatureParam   = "signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signature has expired")
+	ErrUnknownKey       = errors.New("unknown signing key")
+)
+
+// Params is everything covered by the signature. MaxSize and ContentType are
+// only meaningful for uploads and may be left empty for downloads.
+type Params struct {
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	MaxSize     int64     `json:"max_size,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Expires     time.Time `json:"expires_at"`
+}
+
+// Signer issues and verifies HMAC-SHA256 signed URLs. New URLs are signed
+// with the active key, while every configured key is accepted when
+// verifying, so keys can be rotated by adding a new one, making it active
+// and removing the old one once its URLs have expired.
+type Signer struct {
+	activeKeyID string
+	keys        map[string][]byte
+}
+
+func NewSigner(activeKeyID string, keys map[string]string) (*Signer, error) {
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeKeyID)
+	}
+
+	s := &Signer{activeKeyID: activeKeyID, keys: map[string][]byte{}}
+	for id, secret := range keys {
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("signing key %q must be at least 32 characters", id)
+		}
+		if strings.HasPrefix(secret, "change-me") {
+			return nil, fmt.Errorf("signing key %q is a placeholder, set a random secret", id)
+		}
+		s.keys[id] = []byte(secret)
+	}
+
+	return s, nil
+}
+
+// Sign returns the query string to append to p.Path.
+func (s *Signer) Sign(p Params) url.Values {
+	q := url.Values{}
+	q.Set(KeyIDParam, s.activeKeyID)
+	q.Set(ExpiresParam, strconv.FormatInt(p.Expires.Unix(), 10))
+	if p.MaxSize > 0 {
+		q.Set(MaxSizeParam, strconv.FormatInt(p.MaxSize, 10))
+	}
+	if p.ContentType != "" {
+		q.Set(ContentTypeParam, p.ContentType)
+	}
+	q.Set(SignatureParam, s.signature(s.keys[s.activeKeyID], s.activeKeyID, p))
+
+	return q
+}
+
+// Verify checks the signature in q against the request method and path and
+// returns the signed parameters.
+func (s *Signer) Verify(method, path string, q url.Values, now time.Time) (*Params, error) {
+	keyID := q.Get(KeyIDParam)
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	expires, err := strconv.ParseInt(q.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	p := &Params{
+		Method:      method,
+		Path:        path,
+		ContentType: q.Get(ContentTypeParam),
+		Expires:     time.Unix(expires, 0),
+	}
+
+	if s := q.Get(MaxSizeParam); s != "" {
+		p.MaxSize, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	expected := s.signature(key, keyID, *p)
+	if !hmac.Equal([]byte(expected), []byte(q.Get(SignatureParam))) {
+		return nil, ErrInvalidSignature
+	}
+
+	if now.After(p.Expires) {
+		return nil, ErrExpired
+	}
+
+	return p, nil
+}
+
+func (s *Signer) signature(key []byte, keyID string, p Params) string {
+	canonical := strings.Join([]string{
+		keyID,
+		strings.ToUpper(p.Method),
+		p.Path,
+		strconv.FormatInt(p.MaxSize, 10),
+		p.ContentType,
+		strconv.FormatInt(p.Expires.Unix(), 10),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type contextKey struct{}
+
+// ContextWithParams stores the verified parameters of a presigned request.
+func ContextWithParams(ctx context.Context, p *Params) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// ParamsFromContext returns the verified parameters, or nil if the request
+// was not made with a presigned URL.
+func ParamsFromContext(ctx context.Context) *Params {
+	p, _ := ctx.Value(contextKey{}).(*Params)
+	return p
+}
@@ -0,0 +1,144 @@
+package presign
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret      = "0123456789abcdef0123456789abcdef"
+	testOtherSecret = "fedcba9876543210fedcba9876543210"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner("k1", map[string]string{"k1": testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	signed := Params{
+		Method:      "POST",
+		Path:        "/upload",
+		MaxSize:     5 << 20,
+		ContentType: "image/jpeg",
+		Expires:     now.Add(10 * time.Minute),
+	}
+	q := signer.Sign(signed)
+
+	tamper := func(key, value string) url.Values {
+		copied := url.Values{}
+		for k, v := range q {
+			copied[k] = append([]string(nil), v...)
+		}
+		if value == "" {
+			copied.Del(key)
+		} else {
+			copied.Set(key, value)
+		}
+		return copied
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		q       url.Values
+		now     time.Time
+		wantErr error
+	}{
+		{"as signed", "POST", "/upload", q, now, nil},
+		{"lower case method", "post", "/upload", q, now, nil},
+		{"other method", "PUT", "/upload", q, now, ErrInvalidSignature},
+		{"other path", "POST", "/upload/url", q, now, ErrInvalidSignature},
+		{"larger size", "POST", "/upload", tamper(MaxSizeParam, "10485760"), now, ErrInvalidSignature},
+		{"size removed", "POST", "/upload", tamper(MaxSizeParam, ""), now, ErrInvalidSignature},
+		{"other content type", "POST", "/upload", tamper(ContentTypeParam, "image/png"), now, ErrInvalidSignature},
+		{"later expiry", "POST", "/upload", tamper(ExpiresParam, "1800000000"), now, ErrInvalidSignature},
+		{"invalid expiry", "POST", "/upload", tamper(ExpiresParam, "soon"), now, ErrInvalidSignature},
+		{"other signature", "POST", "/upload", tamper(SignatureParam, "AAAA"), now, ErrInvalidSignature},
+		{"unknown key", "POST", "/upload", tamper(KeyIDParam, "k2"), now, ErrUnknownKey},
+		{"expired", "POST", "/upload", q, now.Add(11 * time.Minute), ErrExpired},
+	}
+
+	for _, tt := range tests {
+		p, err := signer.Verify(tt.method, tt.path, tt.q, tt.now)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (p.MaxSize != signed.MaxSize || p.ContentType != signed.ContentType || !p.Expires.Equal(signed.Expires)) {
+			t.Errorf("%s: got params %+v", tt.name, p)
+		}
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	old, err := NewSigner("k1", map[string]string{"k1": testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// k2 is active, k1 is still accepted until its URLs expired
+	rotated, err := NewSigner("k2", map[string]string{"k1": testSecret, "k2": testOtherSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// k2 alone, k1 has been dropped
+	dropped, err := NewSigner("k2", map[string]string{"k2": testOtherSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	p := Params{Method: "GET", Path: "/image/1/file", Expires: now.Add(time.Minute)}
+
+	_, err = rotated.Verify(p.Method, p.Path, old.Sign(p), now)
+	if err != nil {
+		t.Errorf("old key after rotation: %v", err)
+	}
+
+	_, err = dropped.Verify(p.Method, p.Path, old.Sign(p), now)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("dropped key: got error %v, want %v", err, ErrUnknownKey)
+	}
+
+	// the same key id with another secret
+	_, err = old.Verify(p.Method, p.Path, dropped.Sign(p), now)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("key k2 unknown to the old signer: got error %v, want %v", err, ErrUnknownKey)
+	}
+
+	impostor, err := NewSigner("k1", map[string]string{"k1": testOtherSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Verify(p.Method, p.Path, impostor.Sign(p), now)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other secret for k1: got error %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name    string
+		active  string
+		keys    map[string]string
+		wantErr bool
+	}{
+		{"valid", "k1", map[string]string{"k1": testSecret}, false},
+		{"active key missing", "k2", map[string]string{"k1": testSecret}, true},
+		{"short key", "k1", map[string]string{"k1": "short"}, true},
+		{"placeholder", "k1", map[string]string{"k1": "change-me-to-a-long-random-secret-value"}, true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewSigner(tt.active, tt.keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
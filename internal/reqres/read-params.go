@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -22,6 +24,19 @@ func ReadIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// ReadNamedIDParam - read a positive integer id from the named parameter,
+// e.g. "image_id" in /albums/:id/images/:image_id
+func ReadNamedIDParam(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return id, nil
+}
+
 // ReadLimitParam - read "limit" from query parameters
 func ReadLimitParam(r *http.Request) (int64, error) {
 	limitParam := r.URL.Query().Get("limit")
@@ -52,6 +67,28 @@ func ReadOffsetParam(r *http.Request) (int64, error) {
 	return offset, nil
 }
 
+// ReadIfMatchVersion - read a record version from the "If-Match" header,
+// e.g. `If-Match: "3"`. ok is false when the header is absent or "*"
+func ReadIfMatchVersion(r *http.Request) (version int32, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	v, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 32)
+	if err != nil || v < 1 {
+		return 0, false, errors.New("invalid If-Match header")
+	}
+
+	return int32(v), true, nil
+}
+
+// VersionETag - format a record version as an entity tag for "If-Match"
+func VersionETag(version int32) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
 func ReadInt(qs url.Values, key string, defaultValue int) (int, error) {
 	s := qs.Get(key)
 
@@ -67,6 +104,57 @@ func ReadInt(qs url.Values, key string, defaultValue int) (int, error) {
 	return i, nil
 }
 
+func ReadInt64(qs url.Values, key string, defaultValue int64) (int64, error) {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return defaultValue, fmt.Errorf("must be an integer value")
+	}
+
+	return i, nil
+}
+
+// ReadBool - read a boolean such as "true", "false", "1" or "0"
+func ReadBool(qs url.Values, key string, defaultValue bool) (bool, error) {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid %s parameter", key)
+	}
+
+	return b, nil
+}
+
+// ReadTime - read an RFC 3339 timestamp or a date such as "2024-05-01"
+// (midnight UTC). The zero time is returned when the parameter is absent
+func ReadTime(qs url.Values, key string) (time.Time, error) {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+
+	return t, nil
+}
+
 func ReadString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -76,3 +164,17 @@ func ReadString(qs url.Values, key string, defaultValue string) string {
 
 	return s
 }
+
+// ReadStrings - read every value of a repeated query parameter, e.g.
+// "?tag=a&tag=b". Empty values are skipped
+func ReadStrings(qs url.Values, key string) []string {
+	values := []string{}
+
+	for _, s := range qs[key] {
+		if s != "" {
+			values = append(values, s)
+		}
+	}
+
+	return values
+}
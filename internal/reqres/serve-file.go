@@ -0,0 +1,72 @@
+package reqres
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ServeFile() - helper for streaming stored file data to frontend
+//
+// content is read on demand, so only the requested byte ranges are loaded.
+// Conditional requests (If-None-Match, If-Modified-Since, If-Range) and Range
+// requests are handled by http.ServeContent using the given etag and modtime.
+//
+// example:
+//
+// ServeFile(w, r, obj, "image/jpeg", `"<sha256>"`, image.UploadTimestamp,
+// ContentDisposition("inline", image.OriginalFilename))
+func ServeFile(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType, etag string, modtime time.Time, disposition string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+
+	// the name is only used for content type detection, which is skipped
+	// since Content-Type is already set
+	http.ServeContent(w, r, "", modtime, content)
+}
+
+// ContentDisposition builds a Content-Disposition header value ("inline" or
+// "attachment") for a user supplied filename. Path components, quotes and
+// control characters are dropped, non-ASCII names are encoded as RFC 2231
+// filename* parameters.
+func ContentDisposition(dispositionType, filename string) string {
+	name := SanitizeFilename(filename)
+	if name == "" {
+		return dispositionType
+	}
+
+	return mime.FormatMediaType(dispositionType, map[string]string{"filename": name})
+}
+
+// SanitizeFilename strips everything from a client supplied filename that
+// could be used to break out of a header value or a directory.
+func SanitizeFilename(filename string) string {
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	filename = path.Base(filename)
+
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, filename)
+
+	filename = strings.TrimSpace(filename)
+	if filename == "." || filename == ".." {
+		return ""
+	}
+
+	return filename
+}
@@ -5,8 +5,9 @@ import (
 	"net/http"
 )
 
-// Define a writeJSON() helper for sending responses.
-func WriteJSON(w http.ResponseWriter, status int, data map[string]any, headers http.Header) error {
+// WriteJSON sends data, usually one of the response structs of the
+// handlers, as the JSON body of the response
+func WriteJSON[T any](w http.ResponseWriter, status int, data T, headers http.Header) error {
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+const DefaultUploadDir = "/app/uploads"
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage is the backend that holds image bytes. Keys are slash separated
+// paths relative to the root of the backend, e.g. "1700000000_abcd.jpg" or
+// "tus/<upload id>/<offset>".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (Object, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an open handle on a stored file.
+type Object interface {
+	io.ReadSeekCloser
+	Info() *ObjectInfo
+}
+
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
@@ -0,0 +1,45 @@
+package validator
+
+import (
+	"slices"
+	"unicode/utf8"
+)
+
+// Validator collects field errors in the shape expected by
+// ErrorResponse.FailedValidationResponse
+type Validator struct {
+	Errors map[string]string
+}
+
+func New() *Validator {
+	return &Validator{Errors: make(map[string]string)}
+}
+
+// Valid returns true if no errors were added
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError adds an error message for key, keeping the first one per key
+func (v *Validator) AddError(key, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// Check adds an error message only if ok is false
+func (v *Validator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+// PermittedValue returns true if value is one of permittedValues
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
+
+// MaxChars returns true if s has at most n characters (not bytes)
+func MaxChars(s string, n int) bool {
+	return utf8.RuneCountInString(s) <= n
+}
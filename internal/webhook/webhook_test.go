@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	const secret = "whsec_test"
+
+	sentAt := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"event":"image.created","image":{"id":1}}`)
+	signature := Sign(secret, sentAt, body)
+
+	if !strings.HasPrefix(signature, "t=1700000000,v1=") {
+		t.Fatalf("got signature %q", signature)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		body      string
+		tolerance time.Duration
+		now       time.Time
+		valid     bool
+	}{
+		{"as sent", secret, signature, string(body), 5 * time.Minute, sentAt.Add(time.Minute), true},
+		{"without age check", secret, signature, string(body), 0, sentAt.Add(24 * time.Hour), true},
+		{"parts reordered", secret, strings.Join(reverse(strings.Split(signature, ",")), ","), string(body), 5 * time.Minute, sentAt, true},
+		{"expired", secret, signature, string(body), 5 * time.Minute, sentAt.Add(6 * time.Minute), false},
+		{"from the future", secret, signature, string(body), 5 * time.Minute, sentAt.Add(-6 * time.Minute), false},
+		{"tampered body", secret, signature, `{"event":"image.deleted","image":{"id":1}}`, 5 * time.Minute, sentAt, false},
+		{"other secret", "whsec_other", signature, string(body), 5 * time.Minute, sentAt, false},
+		{"replayed with a new timestamp", secret, strings.Replace(signature, "t=1700000000", "t=1700000300", 1), string(body), 5 * time.Minute, sentAt.Add(5 * time.Minute), false},
+		{"no timestamp", secret, signature[strings.Index(signature, "v1="):], string(body), 5 * time.Minute, sentAt, false},
+		{"no signature", secret, "t=1700000000", string(body), 5 * time.Minute, sentAt, false},
+		{"signature not hex", secret, "t=1700000000,v1=zz", string(body), 5 * time.Minute, sentAt, false},
+		{"empty", secret, "", string(body), 5 * time.Minute, sentAt, false},
+	}
+
+	for _, tt := range tests {
+		if got := Verify(tt.secret, tt.signature, []byte(tt.body), tt.tolerance, tt.now); got != tt.valid {
+			t.Errorf("%s: got valid %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func reverse(parts []string) []string {
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return parts
+}
+
+func TestSend(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"image.created"}`)
+
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(context.Background())
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	status, err := Send(context.Background(), srv.Client(), Delivery{ID: 7, URL: srv.URL, Secret: secret, Event: "image.created", Body: body})
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d, error %v", status, err)
+	}
+
+	if got.Header.Get(EventHeader) != "image.created" || got.Header.Get(DeliveryHeader) != strconv.Itoa(7) {
+		t.Errorf("got headers %v", got.Header)
+	}
+	if !Verify(secret, got.Header.Get(SignatureHeader), body, time.Minute, time.Now()) {
+		t.Errorf("signature %q does not verify", got.Header.Get(SignatureHeader))
+	}
+
+	status, err = Send(context.Background(), srv.Client(), Delivery{ID: 8, URL: srv.URL + "/gone", Secret: secret, Body: body})
+	if err == nil || status != http.StatusGone {
+		t.Errorf("gone: got status %d, error %v", status, err)
+	}
+}
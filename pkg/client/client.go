@@ -0,0 +1,245 @@
+// Package client is a Go client for the image upload and view HTTP API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxRetries     = 3
+	DefaultInitialBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+)
+
+type Client struct {
+	baseURL        *url.URL
+	httpClient     *http.Client
+	apiKey         string
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey sends key as a bearer token, needed when the server has
+// auth.apiKeys configured
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetries sets how often a failed request is retried and the backoff
+// before the first retry, which doubles up to maxBackoff. Zero retries
+// disables them.
+func WithRetries(maxRetries int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.initialBackoff = initialBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a client for the API at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: the scheme must be http or https", baseURL)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:        u,
+		httpClient:     http.DefaultClient,
+		maxRetries:     DefaultMaxRetries,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// request describes one API call. newBody is called for every attempt, so a
+// retried request sends its body again; nil means no body.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	contentType string
+	newBody     func() (io.Reader, error)
+	// retryable is false for requests that must not be repeated once the
+	// server may have acted on them
+	retryable bool
+	// once is set when the body can't be produced a second time
+	once bool
+}
+
+// do sends req, retrying on network errors and on responses that say the
+// server is temporarily unable to handle it, and decodes a successful JSON
+// response into dst.
+func (c *Client) do(ctx context.Context, req request, dst any) error {
+	var lastErr error
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			err := sleep(ctx, c.backoff(attempt, lastErr))
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := c.send(ctx, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			lastErr = err
+		} else {
+			lastErr = handleResponse(res, dst)
+			if lastErr == nil {
+				return nil
+			}
+		}
+
+		if attempt >= c.maxRetries || req.once || !shouldRetry(req, lastErr) {
+			return lastErr
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+
+	var body io.Reader
+	if req.newBody != nil {
+		var err error
+		body, err = req.newBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	return c.httpClient.Do(httpReq)
+}
+
+// handleResponse decodes the body of a 2xx response into dst and turns any
+// other response into an *Error
+func handleResponse(res *http.Response, dst any) error {
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		if dst == nil {
+			io.Copy(io.Discard, res.Body)
+			return nil
+		}
+
+		err := json.NewDecoder(res.Body).Decode(dst)
+		if err != nil {
+			return fmt.Errorf("unable to decode response: %v", err)
+		}
+		return nil
+	}
+
+	return newError(res)
+}
+
+func shouldRetry(req request, err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// the request may have reached the server
+		return req.retryable
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// the request was turned away before it was handled
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return req.retryable
+	}
+
+	return false
+}
+
+// backoff returns how long to wait before the given attempt: the server's
+// Retry-After if it sent one, otherwise an exponential backoff with jitter
+func (c *Client) backoff(attempt int, lastErr error) time.Duration {
+	var apiErr *Error
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0 {
+		return min(apiErr.RetryAfter, c.maxBackoff)
+	}
+
+	d := c.initialBackoff << (attempt - 1)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+
+	// between half and the full backoff, so clients don't retry in lockstep
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sentinels to check an *Error against with errors.Is
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("edit conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrValidation         = errors.New("validation failed")
+	ErrServer             = errors.New("server error")
+)
+
+// Error is a response of the API with a non-2xx status. The API reports
+// errors as {"error": "message"}, or {"error": {"field": "message"}} for
+// failed validation.
+type Error struct {
+	StatusCode int
+	// Message is set when the error is a single message
+	Message string
+	// Fields holds the per field messages of a failed validation
+	Fields map[string]string
+	// RetryAfter is the delay the server asked for, if any
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+	}
+
+	fields := make([]string, 0, len(e.Fields))
+	for field, message := range e.Fields {
+		fields = append(fields, field+": "+message)
+	}
+	sort.Strings(fields)
+
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, strings.Join(fields, ", "))
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrValidation:
+		return e.StatusCode == http.StatusUnprocessableEntity
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+
+	return false
+}
+
+func newError(res *http.Response) *Error {
+	apiErr := &Error{
+		StatusCode: res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if json.Unmarshal(raw, &body) == nil && len(body.Error) > 0 {
+		if json.Unmarshal(body.Error, &apiErr.Message) == nil {
+			return apiErr
+		}
+		if json.Unmarshal(body.Error, &apiErr.Fields) == nil {
+			apiErr.Message = "validation failed"
+			return apiErr
+		}
+	}
+
+	// not one of ours, e.g. a proxy in front of the API
+	apiErr.Message = http.StatusText(res.StatusCode)
+	return apiErr
+}
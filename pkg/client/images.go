@@ -0,0 +1,333 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image is the metadata of a stored image as returned by the API
+type Image struct {
+	ID               int64      `json:"id"`
+	Filename         string     `json:"filename"`
+	OriginalFilename string     `json:"original_filename"`
+	URL              string     `json:"url"`
+	FileSize         int64      `json:"file_size"`
+	ContentType      string     `json:"content_type"`
+	Checksum         string     `json:"checksum,omitempty"`
+	FileURL          string     `json:"file_url"`
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	AltText          string     `json:"alt_text"`
+	Tags             []string   `json:"tags"`
+	TakenAt          *time.Time `json:"taken_at"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	Version          int32      `json:"version"`
+	UploadTimestamp  time.Time  `json:"upload_timestamp"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// ProgressFunc is called while an upload is sent with the number of bytes of
+// the file sent so far
+type ProgressFunc func(sent int64)
+
+// Upload sends the image read from src as filename. The content type is taken
+// from the file extension, image/jpeg if it has none. progress may be nil.
+//
+// The upload is only retried when src is an io.Seeker, so it can be read
+// again from the start, and the server turned the previous attempt away.
+func (c *Client) Upload(ctx context.Context, filename string, src io.Reader, progress ProgressFunc) (*Image, error) {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
+	seeker, seekable := src.(io.Seeker)
+	start := int64(0)
+	if seekable {
+		var err error
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the form is streamed through a pipe, so the file is never held in memory
+	mw := multipart.NewWriter(io.Discard)
+	boundary := mw.Boundary()
+
+	var prev *io.PipeReader
+	var prevDone chan struct{}
+
+	newBody := func() (io.Reader, error) {
+		if prev != nil {
+			// the writer of the previous attempt must be done with src
+			// before it is rewound
+			prev.Close()
+			<-prevDone
+
+			_, err := seeker.Seek(start, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pw.CloseWithError(writeUploadForm(pw, boundary, filename, contentType, src, progress))
+		}()
+
+		prev, prevDone = pr, done
+		return pr, nil
+	}
+
+	req := request{
+		method:      http.MethodPost,
+		path:        "/upload",
+		contentType: mw.FormDataContentType(),
+		newBody:     newBody,
+		once:        !seekable,
+	}
+
+	var res struct {
+		Image *Image `json:"image"`
+	}
+
+	err := c.do(ctx, req, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Image, nil
+}
+
+func writeUploadForm(w io.Writer, boundary, filename, contentType string, src io.Reader, progress ProgressFunc) error {
+	mw := multipart.NewWriter(w)
+	err := mw.SetBoundary(boundary)
+	if err != nil {
+		return err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "image", "filename": filename}))
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		src = &progressReader{r: src, progress: progress}
+	}
+
+	_, err = io.Copy(part, src)
+	if err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent)
+	}
+	return n, err
+}
+
+// Get returns the image with the given id, ErrNotFound if there is none or
+// it is in the trash
+func (c *Client) Get(ctx context.Context, id int64) (*Image, error) {
+	req := request{
+		method:    http.MethodGet,
+		path:      "/image/" + strconv.FormatInt(id, 10),
+		retryable: true,
+	}
+
+	var res struct {
+		Image *Image `json:"image"`
+	}
+
+	err := c.do(ctx, req, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Image, nil
+}
+
+// Delete moves the image to the trash
+func (c *Client) Delete(ctx context.Context, id int64) error {
+	return c.delete(ctx, id, false)
+}
+
+// DeletePermanently removes the image and its file right away, it needs an
+// admin API key
+func (c *Client) DeletePermanently(ctx context.Context, id int64) error {
+	return c.delete(ctx, id, true)
+}
+
+func (c *Client) delete(ctx context.Context, id int64, permanent bool) error {
+	req := request{
+		method:    http.MethodDelete,
+		path:      "/image/" + strconv.FormatInt(id, 10),
+		retryable: true,
+	}
+
+	if permanent {
+		req.query = url.Values{"permanent": {"true"}}
+	}
+
+	return c.do(ctx, req, nil)
+}
+
+// ListOptions are the filters of GET /images, zero values are left out
+type ListOptions struct {
+	// PageSize is the number of images per request, at most 20
+	PageSize int
+	// Descending lists the newest images first, which is the default of
+	// the API; the zero value lists the oldest first
+	Descending     bool
+	Tags           []string
+	MatchAllTags   bool
+	ContentType    string
+	MinSize        int64
+	MaxSize        int64
+	UploadedAfter  time.Time
+	UploadedBefore time.Time
+}
+
+func (o ListOptions) query() url.Values {
+	qs := url.Values{}
+
+	if o.PageSize > 0 {
+		qs.Set("limit", strconv.Itoa(o.PageSize))
+	}
+
+	// cursor pagination only supports sorting by upload time
+	if o.Descending {
+		qs.Set("sort", "-upload_timestamp")
+	} else {
+		qs.Set("sort", "upload_timestamp")
+	}
+
+	for _, tag := range o.Tags {
+		qs.Add("tag", tag)
+	}
+	if o.MatchAllTags {
+		qs.Set("match", "all")
+	}
+	if o.ContentType != "" {
+		qs.Set("content_type", o.ContentType)
+	}
+	if o.MinSize > 0 {
+		qs.Set("min_size", strconv.FormatInt(o.MinSize, 10))
+	}
+	if o.MaxSize > 0 {
+		qs.Set("max_size", strconv.FormatInt(o.MaxSize, 10))
+	}
+	if !o.UploadedAfter.IsZero() {
+		qs.Set("uploaded_after", o.UploadedAfter.Format(time.RFC3339))
+	}
+	if !o.UploadedBefore.IsZero() {
+		qs.Set("uploaded_before", o.UploadedBefore.Format(time.RFC3339))
+	}
+
+	return qs
+}
+
+// Page is one page of images
+type Page struct {
+	Images []*Image
+	// NextCursor is passed to ListPage for the following page, empty on the
+	// last one
+	NextCursor string
+}
+
+// ListPage returns the page of images after cursor, an empty cursor for the
+// first page. Pages stay stable while new images are uploaded.
+func (c *Client) ListPage(ctx context.Context, opts ListOptions, cursor string) (*Page, error) {
+	qs := opts.query()
+	qs.Set("cursor", cursor)
+
+	req := request{
+		method:    http.MethodGet,
+		path:      "/images",
+		query:     qs,
+		retryable: true,
+	}
+
+	var res struct {
+		Images   []*Image `json:"images"`
+		Metadata struct {
+			NextCursor *string `json:"next_cursor"`
+		} `json:"metadata"`
+	}
+
+	err := c.do(ctx, req, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Images: res.Images}
+	if res.Metadata.NextCursor != nil {
+		page.NextCursor = *res.Metadata.NextCursor
+	}
+
+	return page, nil
+}
+
+// List iterates over all images matching opts, fetching pages as needed. It
+// stops after the first error.
+//
+//	for image, err := range c.List(ctx, client.ListOptions{Tags: []string{"holiday"}}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(image.ID)
+//	}
+func (c *Client) List(ctx context.Context, opts ListOptions) iter.Seq2[*Image, error] {
+	return func(yield func(*Image, error) bool) {
+		cursor := ""
+		for {
+			page, err := c.ListPage(ctx, opts, cursor)
+			if err != nil {
+				yield(nil, fmt.Errorf("unable to list images: %w", err))
+				return
+			}
+
+			for _, image := range page.Images {
+				if !yield(image, nil) {
+					return
+				}
+			}
+
+			if page.NextCursor == "" {
+				return
+			}
+			cursor = page.NextCursor
+		}
+	}
+}
@@ -9,7 +9,11 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-type envelope map[string]any
+// errorBody is the body of every error response, message is a string or a
+// map of field names to messages
+type errorBody struct {
+	Error any `json:"error"`
+}
 
 type ErrorResponse struct {
 	logger *zerolog.Logger
@@ -34,9 +38,7 @@ func (h *ErrorResponse) LogError(r *http.Request, err error) {
 }
 
 func (h *ErrorResponse) ErrorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
-
-	err := writeJSON(w, status, env, nil)
+	err := writeJSON(w, status, errorBody{Error: message}, nil)
 	if err != nil {
 		h.LogError(r, err)
 		w.WriteHeader(500)
@@ -70,6 +72,16 @@ func (h *ErrorResponse) FailedValidationResponse(w http.ResponseWriter, r *http.
 	h.ErrorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+func (h *ErrorResponse) EditConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	h.ErrorResponse(w, r, http.StatusConflict, message)
+}
+
+func (h *ErrorResponse) PreconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since it was last retrieved"
+	h.ErrorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (h *ErrorResponse) InvalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
@@ -82,7 +94,12 @@ func (h *ErrorResponse) AuthenticationRequiredResponse(w http.ResponseWriter, r
 	h.ErrorResponse(w, r, http.StatusUnauthorized, message)
 }
 
-func writeJSON(w http.ResponseWriter, status int, data map[string]any, headers http.Header) error {
+func (h *ErrorResponse) NotPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your API key doesn't have the necessary permissions to access this resource"
+	h.ErrorResponse(w, r, http.StatusForbidden, message)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data errorBody, headers http.Header) error {
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
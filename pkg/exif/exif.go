@@ -0,0 +1,162 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("exif date not found")
+
+const (
+	tagDateTime         = 0x0132
+	tagExifIFDPointer   = 0x8769
+	tagDateTimeOriginal = 0x9003
+
+	typeASCII = 2
+	typeLong  = 4
+
+	dateTimeLayout = "2006:01:02 15:04:05"
+	exifHeader     = "Exif\x00\x00"
+	ifdEntrySize   = 12
+	maxIFDEntries  = 1000
+)
+
+// DateTimeOriginal returns when a JPEG was taken, read from the EXIF
+// DateTimeOriginal tag and falling back to DateTime. EXIF dates carry no time
+// zone, so the result is the camera's wall clock time in UTC. Only the head
+// of the file is needed, the EXIF segment comes before the image data.
+func DateTimeOriginal(jpeg []byte) (time.Time, error) {
+	tiff, err := exifSegment(jpeg)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return time.Time{}, ErrNotFound
+	}
+
+	if len(tiff) < 8 {
+		return time.Time{}, ErrNotFound
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+
+	if pointer, ok := ifd0[tagExifIFDPointer]; ok && pointer.typ == typeLong {
+		exifIFD := readIFD(tiff, order, order.Uint32(pointer.value[:]))
+		if t, err := parseDate(tiff, order, exifIFD[tagDateTimeOriginal]); err == nil {
+			return t, nil
+		}
+	}
+
+	return parseDate(tiff, order, ifd0[tagDateTime])
+}
+
+// exifSegment returns the TIFF structure inside the APP1 segment of a JPEG
+func exifSegment(jpeg []byte) ([]byte, error) {
+	// SOI marker
+	if len(jpeg) < 2 || jpeg[0] != 0xFF || jpeg[1] != 0xD8 {
+		return nil, ErrNotFound
+	}
+
+	for i := 2; i+4 <= len(jpeg); {
+		if jpeg[i] != 0xFF {
+			return nil, ErrNotFound
+		}
+
+		marker := jpeg[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		// RSTn, SOI and EOI have no length
+		if marker >= 0xD0 && marker <= 0xD9 {
+			i += 2
+			continue
+		}
+		// SOS, the image data follows
+		if marker == 0xDA {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(jpeg[i+2:]))
+		start, end := i+4, i+2+length
+		if length < 2 || end > len(jpeg) {
+			return nil, ErrNotFound
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(jpeg[start:end], []byte(exifHeader)) {
+			return jpeg[start+len(exifHeader) : end], nil
+		}
+
+		i = end
+	}
+
+	return nil, ErrNotFound
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]ifdEntry {
+	entries := map[uint16]ifdEntry{}
+
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+
+	n := int(order.Uint16(tiff[offset:]))
+	if n > maxIFDEntries {
+		return entries
+	}
+
+	for i := 0; i < n; i++ {
+		pos := int(offset) + 2 + i*ifdEntrySize
+		if pos+ifdEntrySize > len(tiff) {
+			break
+		}
+
+		entry := ifdEntry{
+			typ:   order.Uint16(tiff[pos+2:]),
+			count: order.Uint32(tiff[pos+4:]),
+		}
+		copy(entry.value[:], tiff[pos+8:pos+12])
+		entries[order.Uint16(tiff[pos:])] = entry
+	}
+
+	return entries
+}
+
+func parseDate(tiff []byte, order binary.ByteOrder, entry ifdEntry) (time.Time, error) {
+	if entry.typ != typeASCII || entry.count < uint32(len(dateTimeLayout)) {
+		return time.Time{}, ErrNotFound
+	}
+
+	// ASCII values longer than 4 bytes are stored at an offset
+	offset := uint64(order.Uint32(entry.value[:]))
+	end := offset + uint64(len(dateTimeLayout))
+	if end > uint64(len(tiff)) {
+		return time.Time{}, ErrNotFound
+	}
+
+	s := strings.TrimRight(string(tiff[offset:end]), "\x00 ")
+
+	t, err := time.ParseInLocation(dateTimeLayout, s, time.UTC)
+	if err != nil {
+		return time.Time{}, ErrNotFound
+	}
+
+	return t, nil
+}
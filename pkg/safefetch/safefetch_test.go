@@ -0,0 +1,243 @@
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(opts Options) *Fetcher {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return New(opts)
+}
+
+func TestFetchRejectsLoopbackByDefault(t *testing.T) {
+	var hit bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+
+	_, err := newTestFetcher(Options{}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrDisallowedAddress) {
+		t.Fatalf("got error %v, want %v", err, ErrDisallowedAddress)
+	}
+	if hit {
+		t.Fatal("the server was reached")
+	}
+
+	res, err := newTestFetcher(Options{AllowPrivateNetworks: true}).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("with AllowPrivateNetworks: %v", err)
+	}
+	if string(res.Body) != "jpeg" || res.ContentType != "image/jpeg" {
+		t.Fatalf("got %q (%s)", res.Body, res.ContentType)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscan(r.PathValue("n"), &n)
+		if n == 0 {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("jpeg"))
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/image.jpg", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := newTestFetcher(Options{MaxRedirects: 2, AllowPrivateNetworks: true})
+
+	res, err := f.Fetch(context.Background(), srv.URL+"/hop/2")
+	if err != nil {
+		t.Fatalf("2 redirects: %v", err)
+	}
+	if res.FinalURL.Path != "/hop/0" {
+		t.Fatalf("got final URL %s", res.FinalURL)
+	}
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/hop/3")
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("3 redirects: got error %v, want %v", err, ErrTooManyRedirects)
+	}
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/ftp")
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Fatalf("redirect to ftp: got error %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	body := strings.Repeat("x", 100)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sized", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte(body))
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for range 10 {
+			w.Write([]byte(body[:10]))
+			w.(http.Flusher).Flush()
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		maxSize int64
+		wantErr error
+	}{
+		{"/sized", 100, nil},
+		{"/sized", 99, ErrTooLarge},
+		{"/chunked", 100, nil},
+		{"/chunked", 99, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		f := newTestFetcher(Options{MaxSize: tt.maxSize, AllowPrivateNetworks: true})
+
+		res, err := f.Fetch(context.Background(), srv.URL+tt.path)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s with max %d: got error %v, want %v", tt.path, tt.maxSize, err, tt.wantErr)
+			continue
+		}
+		if err == nil && len(res.Body) != len(body) {
+			t.Errorf("%s with max %d: got %d bytes", tt.path, tt.maxSize, len(res.Body))
+		}
+	}
+}
+
+func TestFetchContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(Options{AllowedContentTypes: []string{"image/jpeg"}, AllowPrivateNetworks: true})
+
+	tests := []struct {
+		contentType string
+		wantErr     error
+	}{
+		{"image/jpeg", nil},
+		{"image/jpeg; charset=binary", nil},
+		{"text/html", ErrDisallowedContentType},
+		{"", ErrDisallowedContentType},
+	}
+
+	for _, tt := range tests {
+		_, err := f.Fetch(context.Background(), srv.URL+"/?type="+url.QueryEscape(tt.contentType))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%q: got error %v, want %v", tt.contentType, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	f := newTestFetcher(Options{AllowPrivateNetworks: true})
+
+	_, err := f.Fetch(context.Background(), srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("not found: got error %v", err)
+	}
+
+	_, err = f.Fetch(context.Background(), "file:///etc/passwd")
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("file URL: got error %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:80", false},
+		{"0.0.0.0:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[fc00::1]:80", false},
+		{"[fe80::1]:80", false},
+	}
+
+	for _, tt := range tests {
+		err := checkAddress(tt.address)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("%s: got error %v, want allowed %v", tt.address, err, tt.allowed)
+		}
+	}
+}
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"localhost", false},
+		{"LOCALHOST.", false},
+		{"api.localhost", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"[::1]", false},
+		{"10.0.0.1", false},
+		{"169.254.169.254", false},
+		{"::ffff:192.168.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := AllowedHost(tt.host); got != tt.allowed {
+			t.Errorf("%s: got %v, want %v", tt.host, got, tt.allowed)
+		}
+	}
+}
+
+func TestNewClientRefusesRedirectsAndPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(Options{Timeout: 5 * time.Second}).Post(srv.URL, "application/json", nil)
+	if !errors.Is(err, ErrDisallowedAddress) {
+		t.Errorf("got error %v, want %v", err, ErrDisallowedAddress)
+	}
+
+	_, err = NewClient(Options{Timeout: 5 * time.Second, AllowPrivateNetworks: true}).Post(srv.URL, "application/json", nil)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("with AllowPrivateNetworks: got error %v, want %v", err, ErrTooManyRedirects)
+	}
+}
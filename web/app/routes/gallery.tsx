@@ -14,9 +14,16 @@ interface Image {
   filename: string;
   original_filename: string;
   url: string;
+  file_url: string;
   file_size: number;
   content_type: string;
+  title: string;
+  description: string;
+  alt_text: string;
+  version: number;
   upload_timestamp: string;
+  created_at: string;
+  updated_at: string;
 }
 
 interface GalleryResponse {
@@ -96,6 +103,12 @@ export default function Gallery() {
     }
   };
 
+  // image bytes are streamed by the API from whatever storage backend is used
+  const fileSrc = (image: Image): string => {
+    const apiUrl = import.meta.env.VITE_API_URL || "/api";
+    return `${apiUrl}${image.file_url}`;
+  };
+
   const formatFileSize = (bytes: number): string => {
     if (bytes === 0) return "0 Bytes";
     const k = 1024;
@@ -221,8 +234,8 @@ export default function Gallery() {
               >
                 <div className="aspect-square bg-gray-100 relative">
                   <img
-                    src={image.url}
-                    alt={image.original_filename}
+                    src={fileSrc(image)}
+                    alt={image.alt_text || image.original_filename}
                     className="w-full h-full object-cover"
                     loading="lazy"
                   />
@@ -312,8 +325,8 @@ export default function Gallery() {
               </div>
               <div className="p-4">
                 <img
-                  src={selectedImage.url}
-                  alt={selectedImage.original_filename}
+                  src={fileSrc(selectedImage)}
+                  alt={selectedImage.alt_text || selectedImage.original_filename}
                   className="max-w-full h-auto mx-auto"
                 />
                 <div className="mt-4 text-sm text-gray-600 space-y-1">